@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long a generated data export can be downloaded for
+const dataExportTTL = 24 * time.Hour
+
+// the authenticated user's own account
+// ?include=permissions adds the permissions they hold
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	include := app.readIncludes(r.URL.Query(), []string{"permissions"}, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	embedded := map[string]any{}
+	if include.has("permissions") {
+		permissions, err := app.models.Permissions.GetUserPermissions(user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		embedded["permissions"] = permissions
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"user": embed(user, embedded)}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// builds the archive in the background since it touches several tables
+// the user gets an E-Mail with a download token once it's ready
+func (app *application) createDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	app.background(func() {
+		err := app.exportUserData(user)
+		if err != nil {
+			app.logger.Error(err.Error(), "user_id", user.ID)
+		}
+	})
+
+	env := envelope{"message": "we are preparing your data export, you'll receive an E-Mail with download instructions shortly"}
+
+	err := app.writeJSON(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exportUserData(user *data.User) error {
+	archive, err := app.buildUserArchive(user)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.MarshalIndent(archive, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Exports.Upsert(&data.UserExport{
+		UserID:  user.ID,
+		Archive: js,
+		Expiry:  time.Now().Add(dataExportTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	// only the most recent download token should be valid
+	err = app.models.Tokens.Delete(data.ScopeDataExport, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, dataExportTTL, data.ScopeDataExport)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"name":        user.Name,
+		"exportToken": token.PlainText,
+	}
+
+	return app.mailer.Send(user.Email, "data_export_ready.tmpl.html", data)
+}
+
+// collects everything tied to the user
+// anything new we store about users should be added here
+func (app *application) buildUserArchive(user *data.User) (*data.UserArchive, error) {
+	permissions, err := app.models.Permissions.GetUserPermissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	watchlist, err := app.models.Watchlist.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	watched, err := app.models.Watched.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := app.models.Collections.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	collectionEntries, err := app.models.CollectionEntries.GetAllAddedBy(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &data.UserArchive{
+		GeneratedAt:       time.Now(),
+		Profile:           user,
+		Permissions:       permissions,
+		Tokens:            tokens,
+		Reviews:           reviews,
+		Watchlist:         watchlist,
+		Watched:           watched,
+		Collections:       collections,
+		CollectionEntries: collectionEntries,
+	}
+
+	return archive, nil
+}
+
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlainTextToken string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidatePlainTextToken(v, input.PlainTextToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetUserByToken(data.ScopeDataExport, input.PlainTextToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired export token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	export, err := app.models.Exports.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired export token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the archive is already JSON encoded so we skip writeJSON
+	// and hand it over as a file download
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-export-%d.json"`, user.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// accounts aren't removed straight away
+// they're flagged for deletion and purged by a background job once the grace period elapses
+// logging in during the grace period cancels the deletion
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePlainTextPassword(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	_, err = user.Password.Matches(input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			v.AddError("password", "incorrect password")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deleteAt := time.Now().Add(app.config.accounts.deletionGracePeriod)
+	user.SetStatus(data.StatusPendingDeletion, "requested by user", &deleteAt)
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.Delete(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":     user.Name,
+			"deleteAt": deleteAt,
+		}
+
+		err := app.mailer.Send(user.Email, "account_deletion_scheduled.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{
+		"message":   "your account is scheduled for deletion, log in again before the deletion date to cancel",
+		"delete_at": deleteAt,
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
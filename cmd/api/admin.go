@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"` // optional, a missing value suspends the account indefinitely
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateSuspension(v, input.Reason, input.Until); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// admins shouldn't be able to lock themselves out
+	if id == app.contextGetUser(r).ID {
+		v.AddError("id", "you cannot suspend your own account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.SetStatus(data.StatusSuspended, input.Reason, input.Until)
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// log the user out of every session
+	err = app.models.Tokens.Delete(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":   user.Name,
+			"reason": user.StatusReason,
+			"until":  user.StatusUntil,
+		}
+
+		err := app.mailer.Send(user.Email, "user_suspended.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) liftUserSuspensionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Status != data.StatusSuspended {
+		v := validator.New()
+		v.AddError("status", "user account is not suspended")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.SetStatus(data.StatusActive, "", nil)
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name": user.Name,
+		}
+
+		err := app.mailer.Send(user.Email, "user_suspension_lifted.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// mints a short-lived token that lets support staff see the API as the target user does
+func (app *application) createImpersonationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// no impersonation chains
+	if app.isImpersonating(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+	v := validator.New()
+
+	if id == actor.ID {
+		v.AddError("id", "you cannot impersonate yourself")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated || user.Status != data.StatusActive {
+		v.AddError("id", "only active accounts can be impersonated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// impersonating must never grant the actor more than they already hold
+	// and accounts that manage other users are off limits altogether
+	permissions, err := app.models.Permissions.GetUserPermissions(user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	actorPermissions, err := app.models.Permissions.GetUserPermissions(actor.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions.Includes(data.PermissionUsersAdmin) || permissions.Includes(data.PermissionUsersImpersonate) ||
+		!permissions.SubsetOf(actorPermissions) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, actor.ID, app.config.impersonation.ttl)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("impersonation token issued", "user_id", user.ID, "actor_id", actor.ID, "expiry", token.Expiry)
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"impersonation_token": token, "user": user}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+)
+
+// consts for the operations a batch can contain
+const (
+	batchOpCreate = "create"
+	batchOpUpdate = "update"
+	batchOpDelete = "delete"
+)
+
+const maxBatchOperations = 100
+
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int    `json:"id"`      // movie to update or delete
+	Version *int32 `json:"version"` // version the client expects the movie to be at, required for updates
+	Movie   struct {
+		Title       *string           `json:"title"`
+		Year        *int32            `json:"year"`
+		Runtime     *data.Runtime     `json:"runtime"`
+		Genres      []string          `json:"genres"`
+		ExternalIDs *data.ExternalIDs `json:"external_ids"`
+	} `json:"movie"` // the new movie for creates, the fields to change for updates
+}
+
+// outcome of a single operation
+// status and error match what the equivalent single movie request would have returned
+type batchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// returned from within a batch transaction to roll it back
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// applies a list of create, update and delete operations
+// ?atomic=true (the default) applies all of them in a single transaction, the first failure rolls back the rest
+// ?atomic=false applies each on its own and reports which ones failed
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	atomic := app.readBool(r.URL.Query(), "atomic", true, v)
+
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
+	}
+
+	userID := app.contextGetRealUser(r).ID
+	results := make([]batchResult, len(input.Operations))
+
+	if atomic {
+		failed := -1
+
+		err = app.models.Movies.Batch(userID, func(t *data.MovieTx) error {
+			for i, op := range input.Operations {
+				results[i] = app.applyBatchOperation(r, t, genres, i, op)
+				if results[i].Status >= 400 {
+					failed = i
+					return errBatchOperationFailed
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchOperationFailed) {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		// nothing has been applied, the batch fails the same way the failed operation did
+		if failed >= 0 {
+			for i := range results {
+				if i != failed {
+					results[i] = batchResult{
+						Index:  i,
+						Status: http.StatusFailedDependency,
+						Error:  fmt.Sprintf("not applied because operation %d failed", failed),
+					}
+				}
+			}
+
+			app.writeBatchResults(w, r, results[failed].Status, results)
+			return
+		}
+
+		app.writeBatchResults(w, r, http.StatusOK, results)
+		return
+	}
+
+	status := http.StatusOK
+	for i, op := range input.Operations {
+		err = app.models.Movies.Batch(userID, func(t *data.MovieTx) error {
+			results[i] = app.applyBatchOperation(r, t, genres, i, op)
+			if results[i].Status >= 400 {
+				return errBatchOperationFailed
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchOperationFailed) {
+			app.logError(r, err)
+			results[i] = batchResult{Index: i, Status: http.StatusInternalServerError, Error: internalServerErrorMessage}
+		}
+
+		// some operations may have been applied while others weren't
+		if results[i].Status >= 400 {
+			status = http.StatusMultiStatus
+		}
+	}
+
+	app.writeBatchResults(w, r, status, results)
+}
+
+func (app *application) writeBatchResults(w http.ResponseWriter, r *http.Request, status int, results []batchResult) {
+	err := app.writeJSON(w, r, status, envelope{"results": results}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// applies op within t the same way the single movie handlers would
+func (app *application) applyBatchOperation(r *http.Request, t *data.MovieTx, genres *data.GenreTaxonomy, index int, op batchOperation) batchResult {
+	result := batchResult{Index: index}
+
+	fail := func(status int, message any) batchResult {
+		result.Status = status
+		result.Error = message
+		return result
+	}
+
+	internalError := func(err error) batchResult {
+		app.logError(r, err)
+		return fail(http.StatusInternalServerError, internalServerErrorMessage)
+	}
+
+	var movie *data.Movie
+
+	switch op.Op {
+	case batchOpCreate:
+		movie = &data.Movie{}
+		result.Status = http.StatusCreated
+	case batchOpUpdate, batchOpDelete:
+		if op.Op == batchOpUpdate && op.Version == nil {
+			return fail(http.StatusUnprocessableEntity, map[string]string{"version": "must be provided"})
+		}
+
+		var err error
+		movie, err = t.Get(op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return fail(http.StatusNotFound, notFoundMessage)
+			default:
+				return internalError(err)
+			}
+		}
+
+		if op.Version != nil && *op.Version != movie.Version {
+			return fail(http.StatusConflict, editConflictMessage)
+		}
+
+		result.Status = http.StatusOK
+	default:
+		return fail(http.StatusUnprocessableEntity, map[string]string{"op": "must be one of create, update or delete"})
+	}
+
+	if op.Op == batchOpDelete {
+		err := t.Delete(movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return fail(http.StatusConflict, editConflictMessage)
+			default:
+				return internalError(err)
+			}
+		}
+
+		result.Movie = movie
+		return result
+	}
+
+	if op.Movie.Title != nil {
+		movie.Title = *op.Movie.Title
+	}
+	if op.Movie.Year != nil {
+		movie.Year = *op.Movie.Year
+	}
+	if op.Movie.Runtime != nil {
+		movie.Runtime = *op.Movie.Runtime
+	}
+	if op.Movie.Genres != nil {
+		movie.Genres = op.Movie.Genres
+	}
+	if op.Movie.ExternalIDs != nil {
+		movie.ExternalIDs = *op.Movie.ExternalIDs
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		return fail(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	var err error
+	if op.Op == batchOpCreate {
+		err = t.Insert(movie)
+	} else {
+		err = t.Update(movie)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return fail(http.StatusConflict, editConflictMessage)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			return fail(http.StatusUnprocessableEntity, map[string]string{"external_ids": "a movie with one of these external ids already exists"})
+		default:
+			return internalError(err)
+		}
+	}
+
+	result.Movie = movie
+	return result
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// catalogue export formats
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// movies read from the database and written to the client at a time
+const exportBatchSize = 500
+
+var movieCSVHeader = []string{"id", "title", "year", "runtime", "genres", "imdb_id", "tmdb_id", "wikidata_id", "version"}
+
+const (
+	// 10MB, enough for tens of thousands of movies
+	importMaxBytes = 10 << 20
+	// imports with more rows than this run as a background job
+	importSyncLimit = 1000
+)
+
+// ?format= takes precedence over the Accept header, NDJSON is the default
+func (app *application) readExportFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		return formatCSV
+	}
+
+	return formatNDJSON
+}
+
+// streams every movie matching the list filters
+// rows go out as they're read from the database so the response is never buffered in memory
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilter
+		data.Filters
+		Format string
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.MovieFilter = app.readMovieFilter(r, v)
+	input.Sort = app.readString(qs, "sort", "id")
+	input.SortSafeList = []string{"id", "title", "year", "runtime", "rating_average", "rating_count", "-id", "-title", "-year", "-runtime", "-rating_average", "-rating_count"}
+	input.Format = app.readExportFormat(r)
+
+	v.Check(validator.PermittedValue(input.Format, formatNDJSON, formatCSV), "format", "must be one of ndjson or csv")
+	data.ValidateMovieFilter(v, input.MovieFilter)
+	if data.ValidateSort(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// begin writes anything that goes before the first movie
+	var begin func() error
+	var write func(movies []*data.Movie) error
+
+	switch input.Format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+
+		cw := csv.NewWriter(w)
+
+		begin = func() error {
+			cw.Write(movieCSVHeader)
+			cw.Flush()
+			return cw.Error()
+		}
+
+		write = func(movies []*data.Movie) error {
+			for _, movie := range movies {
+				cw.Write([]string{
+					strconv.FormatInt(movie.ID, 10),
+					movie.Title,
+					strconv.Itoa(int(movie.Year)),
+					strconv.Itoa(int(movie.Runtime)),
+					strings.Join(movie.Genres, "|"),
+					movie.ExternalIDs.IMDb,
+					movie.ExternalIDs.TMDb,
+					movie.ExternalIDs.Wikidata,
+					strconv.Itoa(int(movie.Version)),
+				})
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		begin = func() error {
+			return nil
+		}
+
+		enc := json.NewEncoder(w)
+		write = func(movies []*data.Movie) error {
+			for _, movie := range movies {
+				// Encode terminates every movie with a newline
+				err := enc.Encode(movie)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, input.Format))
+
+	// the server's WriteTimeout would cut a long export short
+	// instead the deadline is pushed back every time a batch goes out
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		return rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	}
+	flush := func() error {
+		err := extendDeadline()
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	err := extendDeadline()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// nothing is written until the first batch has been fetched
+	// so that a failing query can still be answered with a 500
+	started := false
+	start := func() error {
+		started = true
+		return begin()
+	}
+
+	err = app.models.Movies.Stream(r.Context(), input.MovieFilter, input.Filters, exportBatchSize, func(movies []*data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := write(movies)
+		if err != nil {
+			return err
+		}
+		return flush()
+	})
+	// no movies matched, the export is still a valid empty file
+	if err == nil && !started {
+		err = start()
+		if err == nil {
+			err = flush()
+		}
+	}
+	if err != nil {
+		if !started {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		// the status code has already been sent, all we can do is cut the response short
+		app.logError(r, err)
+	}
+}
+
+// ?format= takes precedence over the Content-Type header
+func (app *application) readImportFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		return formatCSV
+	}
+
+	return formatNDJSON
+}
+
+// a movie read from an upload, errors holds why it can't be imported
+type importRow struct {
+	line   int
+	movie  *data.Movie
+	errors map[string]string
+}
+
+// CSV uploads use the same columns as the export, id and version are ignored
+// and the external id columns may be left out
+// likewise for NDJSON where every line holds a movie object
+// problems with individual rows are recorded on the row, the error is only
+// returned when the upload as a whole can't be read
+func readImportRows(body io.Reader, format string) ([]*importRow, error) {
+	var rows []*importRow
+
+	switch format {
+	case formatCSV:
+		cr := csv.NewReader(body)
+		cr.TrimLeadingSpace = true
+
+		header, err := cr.Read()
+		if err != nil {
+			return nil, importReadError(err, "body must start with a CSV header")
+		}
+
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, name := range []string{"title", "year", "runtime", "genres"} {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("CSV header must contain a %q column", name)
+			}
+		}
+
+		for {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				rows = append(rows, &importRow{line: parseError.StartLine, errors: map[string]string{"row": parseError.Err.Error()}})
+				continue
+			}
+			if err != nil {
+				return nil, importReadError(err, "")
+			}
+
+			line, _ := cr.FieldPos(0)
+			rows = append(rows, csvImportRow(line, record, columns))
+		}
+	default:
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+
+			var input struct {
+				Title       string           `json:"title"`
+				Year        int32            `json:"year"`
+				Runtime     data.Runtime     `json:"runtime"`
+				Genres      []string         `json:"genres"`
+				ExternalIDs data.ExternalIDs `json:"external_ids"`
+			}
+
+			err := json.Unmarshal([]byte(text), &input)
+			if err != nil {
+				rows = append(rows, &importRow{line: line, errors: map[string]string{"row": "must be a JSON object describing a movie"}})
+				continue
+			}
+
+			movie := &data.Movie{Title: input.Title, Year: input.Year, Runtime: input.Runtime, Genres: input.Genres, ExternalIDs: input.ExternalIDs}
+			rows = append(rows, &importRow{line: line, movie: movie})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, importReadError(err, "")
+		}
+	}
+
+	return rows, nil
+}
+
+func csvImportRow(line int, record []string, columns map[string]int) *importRow {
+	row := &importRow{line: line, movie: &data.Movie{}, errors: map[string]string{}}
+
+	row.movie.Title = strings.TrimSpace(record[columns["title"]])
+
+	year, err := strconv.Atoi(strings.TrimSpace(record[columns["year"]]))
+	if err != nil {
+		row.errors["year"] = "must be an integer value"
+	}
+	row.movie.Year = int32(year)
+
+	runtime, err := strconv.Atoi(strings.TrimSpace(record[columns["runtime"]]))
+	if err != nil {
+		row.errors["runtime"] = "must be an integer value"
+	}
+	row.movie.Runtime = data.Runtime(runtime)
+
+	// genres are separated by | since , already separates the columns
+	row.movie.Genres = []string{}
+	for genre := range strings.SplitSeq(record[columns["genres"]], "|") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			row.movie.Genres = append(row.movie.Genres, genre)
+		}
+	}
+
+	for _, source := range data.ExternalSources {
+		if i, ok := columns[source+"_id"]; ok {
+			row.movie.ExternalIDs.Set(source, strings.TrimSpace(record[i]))
+		}
+	}
+
+	if len(row.errors) == 0 {
+		row.errors = nil
+	}
+
+	return row
+}
+
+// message is used for errors other than the body being too large
+func importReadError(err error, message string) error {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case message != "":
+		return errors.New(message)
+	default:
+		return err
+	}
+}
+
+// runs every row through data.ValidateMovie and rejects external ids that are taken,
+// either by movies in the database or by an earlier row of the upload
+// returns the movies that can be imported along with a report of the rows that can't
+func validateImportRows(rows []*importRow, genres *data.GenreTaxonomy, taken map[string]map[string]bool) ([]*data.Movie, data.ImportReport) {
+	report := data.ImportReport{TotalRows: len(rows), Errors: []data.ImportRowError{}}
+	movies := make([]*data.Movie, 0, len(rows))
+
+	seen := make(map[string]map[string]bool, len(data.ExternalSources))
+	for _, source := range data.ExternalSources {
+		seen[source] = make(map[string]bool)
+	}
+
+	for _, row := range rows {
+		if row.errors == nil {
+			v := validator.New()
+			if data.ValidateMovie(v, row.movie, genres); !v.Valid() {
+				row.errors = v.Errors
+			}
+		}
+
+		if row.errors == nil {
+			v := validator.New()
+			for _, source := range data.ExternalSources {
+				id := row.movie.ExternalIDs.Get(source)
+				if id == "" {
+					continue
+				}
+
+				v.Check(!taken[source][id], "external_ids."+source, "a movie with this id already exists")
+				v.Check(!seen[source][id], "external_ids."+source, "must not be used by more than one row")
+				seen[source][id] = true
+			}
+			if !v.Valid() {
+				row.errors = v.Errors
+			}
+		}
+
+		if row.errors != nil {
+			report.Errors = append(report.Errors, data.ImportRowError{Line: row.line, Errors: row.errors})
+			continue
+		}
+
+		movies = append(movies, row.movie)
+	}
+
+	report.ValidRows = len(movies)
+
+	return movies, report
+}
+
+// imports movies from a CSV or NDJSON upload
+// ?dry_run=true only reports which rows would be rejected
+// small imports finish within the request, larger ones are handed to a background job
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	format := app.readImportFormat(r)
+	dryRun := app.readBool(qs, "dry_run", false, v)
+
+	if v.Check(validator.PermittedValue(format, formatNDJSON, formatCSV), "format", "must be one of ndjson or csv"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+	rows, err := readImportRows(r.Body, format)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
+	}
+
+	taken, err := app.takenExternalIDs(rows)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	movies, report := validateImportRows(rows, genres, taken)
+
+	if dryRun {
+		err = app.writeJSON(w, r, http.StatusOK, envelope{"import": report}, nil)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	userID := app.contextGetRealUser(r).ID
+
+	if len(rows) <= importSyncLimit {
+		err = app.models.Movies.InsertMany(movies, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrDuplicateExternalID):
+				// a movie claimed one of the ids after the rows were checked
+				v.AddError("external_ids", "a movie with one of these external ids already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+		report.ImportedRows = len(movies)
+
+		err = app.writeJSON(w, r, http.StatusCreated, envelope{"import": report}, nil)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	job := &data.ImportJob{Status: data.ImportStatusPending, Format: format, ImportReport: report}
+
+	err = app.models.ImportJobs.Insert(job, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		app.runImportJob(job, movies, userID)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/import/jobs/%d", job.ID))
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"job": job}, headers)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// the external ids of the uploaded movies that movies in the database already hold, by source
+func (app *application) takenExternalIDs(rows []*importRow) (map[string]map[string]bool, error) {
+	taken := make(map[string]map[string]bool, len(data.ExternalSources))
+
+	for _, source := range data.ExternalSources {
+		var ids []string
+		for _, row := range rows {
+			if row.movie != nil && row.movie.ExternalIDs.Get(source) != "" {
+				ids = append(ids, row.movie.ExternalIDs.Get(source))
+			}
+		}
+
+		found, err := app.models.Movies.TakenExternalIDs(source, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		taken[source] = make(map[string]bool, len(found))
+		for _, id := range found {
+			taken[source][id] = true
+		}
+	}
+
+	return taken, nil
+}
+
+func (app *application) runImportJob(job *data.ImportJob, movies []*data.Movie, userID int) {
+	job.Status = data.ImportStatusRunning
+	err := app.models.ImportJobs.UpdateStatus(job)
+	if err != nil {
+		app.logger.Error(err.Error(), "import_job_id", job.ID)
+		return
+	}
+
+	job.Status = data.ImportStatusCompleted
+	err = app.models.Movies.InsertMany(movies, userID)
+	if err != nil {
+		app.logger.Error(err.Error(), "import_job_id", job.ID)
+		job.Status = data.ImportStatusFailed
+	} else {
+		job.ImportedRows = len(movies)
+	}
+
+	err = app.models.ImportJobs.UpdateStatus(job)
+	if err != nil {
+		app.logger.Error(err.Error(), "import_job_id", job.ID)
+	}
+}
+
+func (app *application) showImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.ImportJobs.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the report holds the uploaded rows, other users' jobs look like they don't exist unless the user is a movies admin
+	user := app.contextGetUser(r)
+	if job.CreatedBy == nil || *job.CreatedBy != user.ID {
+		permissions, err := app.models.Permissions.GetUserPermissions(user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Includes(data.PermissionMoviesAdmin) {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
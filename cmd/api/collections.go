@@ -0,0 +1,597 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+)
+
+// public collections only, anyone can browse them without an account
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-updated_at")
+	input.SortSafeList = []string{"id", "title", "created_at", "updated_at", "-id", "-title", "-created_at", "-updated_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAllPublic(input.Title, input.Filters)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// share tokens are for editors only
+	for _, collection := range collections {
+		collection.ShareToken = ""
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// collections the authenticated user owns or collaborates on, whatever their visibility
+func (app *application) listMyCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-updated_at")
+	input.SortSafeList = []string{"id", "title", "created_at", "updated_at", "-id", "-title", "-created_at", "-updated_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAllEditable(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// new collections are private unless asked otherwise
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		OwnerID:     app.contextGetUser(r).ID,
+		Title:       input.Title,
+		Description: input.Description,
+		Visibility:  input.Visibility,
+		ShareToken:  data.NewShareToken(),
+	}
+
+	if collection.Visibility == "" {
+		collection.Visibility = data.CollectionPrivate
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, _, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// collaborators can edit everything but the visibility and the share token, those are up to the owner
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title            *string `json:"title"`
+		Description      *string `json:"description"`
+		Visibility       *string `json:"visibility"`
+		RotateShareToken bool    `json:"rotate_share_token"` // invalidates links handed out so far
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if (input.Visibility != nil || input.RotateShareToken) && collection.OwnerID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.Visibility != nil {
+		collection.Visibility = *input.Visibility
+	}
+	if input.RotateShareToken {
+		collection.ShareToken = data.NewShareToken()
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// only the owner can delete a collection
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	if collection.OwnerID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.models.Collections.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": fmt.Sprintf("collection with id: %d deleted successfully", collection.ID)}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// sorted by position unless asked otherwise
+func (app *application) listCollectionEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	collection, _, ok := app.readCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "position")
+	input.SortSafeList = []string{"position", "added_at", "title", "year", "rating_average",
+		"-position", "-added_at", "-title", "-year", "-rating_average"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.CollectionEntries.GetAll(collection.ID, input.Filters)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"entries": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// the movie goes to the end of the collection unless a position is given
+func (app *application) addCollectionEntryHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieID  int    `json:"movie_id"`
+		Position int32  `json:"position"`
+		Note     string `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must be a positive integer")
+	if data.ValidateCollectionNote(v, input.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "no movie with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	entry := &data.CollectionEntry{
+		CollectionID: collection.ID,
+		Position:     input.Position,
+		Note:         input.Note,
+		AddedBy:      &userID,
+		Movie:        movie,
+	}
+
+	err = app.models.CollectionEntries.Add(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollectionEntry):
+			v.AddError("movie_id", "the movie is already in this collection")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// moves the entry to a new position and/or changes its note
+func (app *application) updateCollectionEntryHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readIntParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := app.models.CollectionEntries.Get(collection.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Position *int32  `json:"position"`
+		Note     *string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Position != nil {
+		v.Check(*input.Position >= 1, "position", "must be a positive integer")
+		entry.Position = *input.Position
+	}
+	if input.Note != nil {
+		entry.Note = *input.Note
+	}
+
+	if data.ValidateCollectionNote(v, entry.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.CollectionEntries.Update(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeCollectionEntryHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readIntParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.CollectionEntries.Remove(collection.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": fmt.Sprintf("movie with id: %d removed from the collection", movieID)}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCollectionCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	collaborators, err := app.models.Collections.GetCollaborators(collection.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collaborators": collaborators}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// only the owner can add collaborators, adding someone twice is a no-op
+func (app *application) addCollectionCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	if collection.OwnerID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	userID, err := app.readIntParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(userID != collection.OwnerID, "user_id", "the owner can already edit the collection"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	collaborator, err := app.models.Collections.AddCollaborator(collection.ID, user)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collaborator": collaborator}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// the owner can remove anyone, collaborators can only remove themselves
+func (app *application) removeCollectionCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readEditableCollection(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readIntParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if collection.OwnerID != user.ID && userID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.RemoveCollaborator(collection.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": fmt.Sprintf("user with id: %d removed from the collaborators", userID)}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// looks up the collection in the URL and reports whether the user can edit it
+// public collections can be read by anyone, unlisted ones by anyone passing ?share_token= as well as editors
+// and private ones by editors only, to everyone else the collection looks like it doesn't exist
+// returns false if a response has already been sent
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request) (*data.Collection, bool, bool) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false, false
+	}
+
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return nil, false, false
+	}
+
+	editor := false
+
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		editor, err = app.models.Collections.CanEdit(collection, user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return nil, false, false
+		}
+	}
+
+	if !editor {
+		shareToken := r.URL.Query().Get("share_token")
+
+		visible := collection.Visibility == data.CollectionPublic ||
+			collection.Visibility == data.CollectionUnlisted && subtle.ConstantTimeCompare([]byte(shareToken), []byte(collection.ShareToken)) == 1
+		if !visible {
+			app.notFoundResponse(w, r)
+			return nil, false, false
+		}
+
+		// share tokens are for editors only
+		collection.ShareToken = ""
+	}
+
+	return collection, editor, true
+}
+
+// like readCollection but only lets editors through
+func (app *application) readEditableCollection(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+	collection, editor, ok := app.readCollection(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if !editor {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return collection, true
+}
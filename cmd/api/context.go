@@ -14,6 +14,10 @@ type contextKey string
 // in the request context
 const userContextKey = contextKey("user")
 
+// holds the admin behind an impersonated request
+// only set while impersonating
+const realUserContextKey = contextKey("real_user")
+
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	// commented out code is prone to key collisions from 3rd party packages
 	// that could be storing data by the same key
@@ -35,3 +39,25 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetRealUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), realUserContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the effective user whose permissions apply to the request
+// contextGetRealUser returns the user actually making the request
+// both are the same unless an admin is impersonating someone
+func (app *application) contextGetRealUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(realUserContextKey).(*data.User)
+	if !ok {
+		return app.contextGetUser(r)
+	}
+
+	return user
+}
+
+func (app *application) isImpersonating(r *http.Request) bool {
+	_, ok := r.Context().Value(realUserContextKey).(*data.User)
+	return ok
+}
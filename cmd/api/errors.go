@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"greenlight/internal/data"
 	"net/http"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -13,9 +15,16 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.Error(err.Error(), "method", method, "uri", uri)
 }
 
+// messages shared by the error responses and the results of batch operations
+const (
+	internalServerErrorMessage = "the server encountered a problem and could not process your request"
+	notFoundMessage            = "the requested resource could not be found"
+	editConflictMessage        = "unable to update the record due to an edit conflict, please try again"
+)
+
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -24,13 +33,11 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 func (app *application) internalServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, internalServerErrorMessage)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, notFoundMessage)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
@@ -47,7 +54,26 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, editConflictMessage)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since you last retrieved it, fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// a JSON Patch test operation found the movie in a different state than the client expected
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+func (app *application) movieInTrashResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the movie linked to this external id is in the trash, restore it first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) personCreditedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the person is still credited on movies, remove their credits first"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
@@ -80,7 +106,48 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+	message := "your user account has been suspended"
+	if user.StatusUntil != nil {
+		message = fmt.Sprintf("your user account has been suspended until %s", user.StatusUntil.Format(time.RFC3339))
+	}
+
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) accountPendingDeletionResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account is scheduled for deletion"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration of new accounts is closed"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) registrationInviteOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "registration of new accounts is by invitation only"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) impersonationReadOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "write requests are not allowed while impersonating a user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) idempotencyInFlightResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "a request with this Idempotency-Key is still being processed, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"greenlight/internal/data"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strong entity tag for a single movie
+// every edit bumps the version, the rating changes with reviews instead so it's part of the tag as well
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%d-%s"`, movie.ID, movie.Version, movie.RatingCount, strconv.FormatFloat(movie.RatingAverage, 'f', -1, 64))
+}
+
+// writes the parts of the movie that identify its representation to a hash
+func hashMovie(hash io.Writer, movie *data.Movie) {
+	binary.Write(hash, binary.BigEndian, movie.ID)
+	binary.Write(hash, binary.BigEndian, movie.Version)
+	binary.Write(hash, binary.BigEndian, movie.RatingCount)
+	binary.Write(hash, binary.BigEndian, movie.RatingAverage)
+}
+
+// weak entity tag for a page of movies
+// it changes whenever a movie on the page, the page's metadata or
+// anything else sent along with the page eg facets changes
+func moviesETag(movies []*data.Movie, metadata data.Metadata, extra ...any) string {
+	hash := sha256.New()
+
+	for _, movie := range movies {
+		hashMovie(hash, movie)
+	}
+	fmt.Fprintf(hash, "%+v", metadata)
+	for _, e := range extra {
+		fmt.Fprintf(hash, "%+v", e)
+	}
+
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(hash.Sum(nil))[:32])
+}
+
+// weak entity tag for a movie sent along with related resources that change without bumping its version
+// it changes with the movie or with any credit, including the credited person's name, or review
+// either list may be nil when it isn't embedded
+func movieRelatedETag(movie *data.Movie, credits []*data.Credit, reviews []*data.Review) string {
+	hash := sha256.New()
+
+	hashMovie(hash, movie)
+	for _, credit := range credits {
+		fmt.Fprintf(hash, "%+v", *credit)
+	}
+	for _, review := range reviews {
+		binary.Write(hash, binary.BigEndian, review.ID)
+		binary.Write(hash, binary.BigEndian, review.Version)
+	}
+
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(hash.Sum(nil))[:32])
+}
+
+// most recent modification time of a page of movies
+func moviesLastModified(movies []*data.Movie) time.Time {
+	var lastModified time.Time
+
+	for _, movie := range movies {
+		if movie.UpdatedAt.After(lastModified) {
+			lastModified = movie.UpdatedAt
+		}
+	}
+
+	return lastModified
+}
+
+// reports whether any entity tag listed in an If-Match or If-None-Match header matches etag
+// If-None-Match uses the weak comparison which ignores the W/ prefix
+// If-Match uses the strong comparison where weak tags never match
+// see RFC 9110 section 8.8.3.2
+func etagMatches(header []string, etag string, weak bool) bool {
+	for _, value := range header {
+		for tag := range strings.SplitSeq(value, ",") {
+			tag = strings.TrimSpace(tag)
+
+			switch {
+			case tag == "*":
+				return true
+			case weak && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/"):
+				return true
+			case !weak && !strings.HasPrefix(tag, "W/") && tag == etag:
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// true when the client's cached copy is still current and a 304 can be sent instead
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Values("If-None-Match")
+	if len(header) == 0 {
+		return false
+	}
+
+	return etagMatches(header, etag, true)
+}
+
+// checks the client's expectation of the movie before it's modified
+// If-Match fails with 412 Precondition Failed
+// X-Expected-Version is a deprecated alias that keeps failing with 409 Conflict
+// returns false if a response has already been sent
+func (app *application) checkMoviePreconditions(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	if header := r.Header.Values("If-Match"); len(header) > 0 {
+		if !etagMatches(header, movieETag(movie), false) {
+			app.preconditionFailedResponse(w, r)
+			return false
+		}
+
+		return true
+	}
+
+	// Round-trip locking
+	// a client sends a header indicating their current movie version and we check this against
+	// what we have in the database and throw an error if they don't match
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		w.Header().Set("Deprecation", "true")
+
+		if strconv.Itoa(int(movie.Version)) != expected {
+			app.editConflictResponse(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
+// headers describing the current representation of a single movie
+func movieHeaders(movie *data.Movie) http.Header {
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+
+	return headers
+}
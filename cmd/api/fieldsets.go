@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"greenlight/internal/validator"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// related resources the client asked to embed eg ?include=revisions
+type includes []string
+
+// includable lists the related resources the endpoint can embed
+func (app *application) readIncludes(qs url.Values, includable []string, v *validator.Validator) includes {
+	i := includes(app.readCSV(qs, "include", []string{}))
+
+	for _, include := range i {
+		if !slices.Contains(includable, include) {
+			if len(includable) == 0 {
+				v.AddError("include", "no related resources can be included")
+			} else {
+				v.AddError("include", fmt.Sprintf("unknown resource %q. use a comma separated list of %s", include, strings.Join(includable, ",")))
+			}
+			break
+		}
+	}
+
+	return i
+}
+
+// reports whether the client asked for the related resource
+func (i includes) has(name string) bool {
+	return slices.Contains(i, name)
+}
+
+// value with the embedded resources added as extra fields
+// value is returned as is when there's nothing to embed
+func embed(value any, embedded map[string]any) any {
+	if len(embedded) == 0 {
+		return value
+	}
+
+	return sparse{value: value, embedded: embedded}
+}
+
+// envelope keys that describe the response rather than hold a resource
+var envelopeMetaKeys = []string{"metadata", "facets", "message"}
+
+// restricts every resource in the envelope to the fields listed in ?fields=id,title
+// a resource is a struct or a list of structs, it keeps the listed fields it has
+// a field none of the resources have is reported back as an error message
+func projectEnvelope(data envelope, fields []string) (envelope, string) {
+	projected := make(envelope, len(data))
+	var known []string
+
+	for key, value := range data {
+		if slices.Contains(envelopeMetaKeys, key) {
+			projected[key] = value
+			continue
+		}
+
+		resourceFields := resourceJSONFields(value)
+		if resourceFields == nil {
+			projected[key] = value
+			continue
+		}
+		known = append(known, resourceFields...)
+
+		projected[key] = project(value, fields)
+	}
+
+	if known == nil {
+		return nil, "no fields can be selected"
+	}
+
+	for _, field := range fields {
+		if !slices.Contains(known, field) {
+			slices.Sort(known)
+			return nil, fmt.Sprintf("unknown field %q. use a comma separated list of %s", field, strings.Join(slices.Compact(known), ","))
+		}
+	}
+
+	return projected, ""
+}
+
+// JSON object keys of a resource, nil if value isn't one
+func resourceJSONFields(value any) []string {
+	if s, ok := value.(sparse); ok {
+		fields := jsonFields(reflect.TypeOf(s.value))
+		for key := range s.embedded {
+			fields = append(fields, key)
+		}
+		return fields
+	}
+
+	return jsonFields(reflect.TypeOf(value))
+}
+
+// value restricted to fields, lists are restricted element by element
+func project(value any, fields []string) any {
+	if s, ok := value.(sparse); ok {
+		s.fields = fields
+		return s
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return sparse{value: value, fields: fields}
+	}
+
+	list := make([]sparse, rv.Len())
+	for i := range rv.Len() {
+		list[i] = sparse{value: rv.Index(i).Interface(), fields: fields}
+	}
+
+	return list
+}
+
+// ?fields= only shapes what a read returns, on writes it would otherwise be silently ignored
+func (app *application) rejectFieldsOnWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("fields") && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			app.failedValidationResponse(w, r, map[string]string{"fields": "can only be used when reading resources"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writes the JSON object of value with only the listed fields plus the embedded resources
+type sparse struct {
+	value    any
+	fields   []string
+	embedded map[string]any
+}
+
+func (s sparse) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(s.value)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(js, &object)
+	if err != nil {
+		return nil, err
+	}
+
+	// nil pointers stay null
+	if object == nil {
+		return js, nil
+	}
+
+	if len(s.fields) > 0 {
+		for key := range object {
+			if !slices.Contains(s.fields, key) {
+				delete(object, key)
+			}
+		}
+	}
+
+	for key, value := range s.embedded {
+		js, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = js
+	}
+
+	return json.Marshal(object)
+}
+
+// names of the JSON object keys of a struct type
+// fields of embedded structs are promoted the same way encoding/json does it
+func jsonFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+
+	return fields
+}
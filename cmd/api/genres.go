@@ -0,0 +1,32 @@
+package main
+
+import (
+	"greenlight/internal/data"
+	"net/http"
+)
+
+// the whole taxonomy, small enough to do without pagination
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// the taxonomy movies are validated against
+// returns false if a response has already been sent
+func (app *application) genreTaxonomy(w http.ResponseWriter, r *http.Request) (*data.GenreTaxonomy, bool) {
+	genres, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	return genres, true
+}
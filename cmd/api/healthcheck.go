@@ -19,7 +19,7 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 	// simulates long running processes
 	// when testing if graceful shutdown works as expected
 	// time.Sleep(4 * time.Second)
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
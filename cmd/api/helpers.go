@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -17,19 +18,37 @@ import (
 type envelope map[string]any
 
 func (app *application) readIdParam(r *http.Request) (int, error) {
+	return app.readIntParam(r, "id")
+}
+
+// reads a positive integer URL parameter eg `:version` in /v1/movies/:id/revisions/:version
+func (app *application) readIntParam(r *http.Request, name string) (int, error) {
 	// any interpolated URL parameters are stored in the request context
 	// We can retrieve a slice containing this parameters from `ParamsFromContext`
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	i, err := strconv.Atoi(params.ByName(name))
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return id, nil
+	return i, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// successful reads are restricted to the fields listed in ?fields= whatever the resource
+// unknown fields get a 422 in place of the response
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	if status < 300 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if fields := app.readCSV(r.URL.Query(), "fields", []string{}); len(fields) > 0 {
+			projected, message := projectEnvelope(data, fields)
+			if message != "" {
+				app.failedValidationResponse(w, r, map[string]string{"fields": message})
+				return nil
+			}
+			data = projected
+		}
+	}
+
 	// js is a []byte slice containing encoded JSON
 	// "" means no prefix for lines and \t indicates that we add tab for each element
 	// json.MarshalIndent uses slightly more memory in comparison to json.Marshal avoid it in memory constrained environments
@@ -147,6 +166,39 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// timestamps are expected in RFC 3339 format eg 2024-01-02T15:04:05Z
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
 // reusable background tasks runner
 // with panic recovery
 func (app *application) background(fn func()) {
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+// records the response written by a handler while passing it through to the client
+type idempotencyRecorder struct {
+	wrapped    http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) Header() http.Header {
+	return rec.wrapped.Header()
+}
+
+func (rec *idempotencyRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.wrapped.WriteHeader(statusCode)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.wrapped.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.wrapped
+}
+
+// requests carrying an Idempotency-Key header are processed at most once per key
+// retries with the same key and body get the stored response replayed
+// the same key with a different body or while the first request is still running gets a 409
+// a key left in flight by a crashed instance can be reused once its lease is up
+// keys are scoped to the user, or the client ip for anonymous requests, and the route
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		// the body is read up front to fingerprint the request and then handed on to the handler
+		// same limit as readJSON
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.Sum256(body)
+
+		owner := "ip:" + realip.FromRequest(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			owner = "user:" + strconv.Itoa(user.ID)
+		}
+
+		record := &data.IdempotencyRecord{
+			Scope:       fmt.Sprintf("%s %s %s", owner, r.Method, r.URL.Path),
+			Key:         key,
+			Fingerprint: fingerprint[:],
+			Expiry:      time.Now().Add(app.config.idempotency.ttl),
+		}
+
+		existing, err := app.models.Idempotency.Reserve(record, app.config.idempotency.lease)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.idempotencyInFlightResponse(w, r)
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.Fingerprint, record.Fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case existing.StatusCode == 0:
+				app.idempotencyInFlightResponse(w, r)
+			default:
+				for name, values := range existing.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{wrapped: w}
+
+		// a panicking handler must not leave the key stuck in flight
+		defer func() {
+			if err := recover(); err != nil {
+				app.releaseIdempotencyKey(r, record)
+				panic(err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		// server errors aren't the client's fault so retries should get another go
+		if rec.statusCode == 0 || rec.statusCode >= 500 {
+			app.releaseIdempotencyKey(r, record)
+			return
+		}
+
+		record.StatusCode = rec.statusCode
+		record.Header = w.Header().Clone()
+		record.Body = rec.body.Bytes()
+
+		err = app.models.Idempotency.Complete(record)
+		if err != nil {
+			// the response has already gone out, the worst case is a retry running the request again
+			app.logError(r, err)
+			app.releaseIdempotencyKey(r, record)
+		}
+	}
+}
+
+func (app *application) releaseIdempotencyKey(r *http.Request, record *data.IdempotencyRecord) {
+	err := app.models.Idempotency.Release(record)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
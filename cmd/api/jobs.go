@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// registers the periodic maintenance jobs
+// they stop once the server starts shutting down
+func (app *application) startJobs() {
+	app.schedule("purge-deleted-accounts", time.Hour, app.purgeDeletedAccounts)
+	app.schedule("purge-expired-exports", time.Hour, app.purgeExpiredExports)
+	app.schedule("purge-trashed-movies", time.Hour, app.purgeTrashedMovies)
+	app.schedule("purge-expired-idempotency-keys", time.Hour, app.purgeExpiredIdempotencyKeys)
+
+	// import jobs interrupted by the previous shutdown are failed straight away rather than at the first tick
+	app.runJob("fail-stale-import-jobs", app.failStaleImportJobs)
+	app.schedule("fail-stale-import-jobs", 5*time.Minute, app.failStaleImportJobs)
+}
+
+// runs fn every interval on a background goroutine tracked by the WaitGroup
+// so that graceful shutdown waits for a run that's already in progress
+func (app *application) schedule(name string, interval time.Duration, fn func() error) {
+	app.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				app.runJob(name, fn)
+			}
+		}
+	})
+}
+
+// a panicking job shouldn't take down the scheduler goroutine
+func (app *application) runJob(name string, fn func() error) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Sprintf("%v", err), "job", name)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		app.logger.Error(err.Error(), "job", name)
+	}
+}
+
+func (app *application) purgeDeletedAccounts() error {
+	deleted, err := app.models.Users.DeletePendingDeletion()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.Info("purged deleted accounts", "count", deleted)
+	}
+
+	return nil
+}
+
+func (app *application) purgeExpiredExports() error {
+	_, err := app.models.Exports.DeleteExpired()
+	return err
+}
+
+func (app *application) purgeTrashedMovies() error {
+	purged, err := app.models.Movies.PurgeDeleted(app.config.trash.retention)
+	if err != nil {
+		return err
+	}
+
+	if purged > 0 {
+		app.logger.Info("purged trashed movies", "count", purged)
+	}
+
+	return nil
+}
+
+func (app *application) purgeExpiredIdempotencyKeys() error {
+	_, err := app.models.Idempotency.DeleteExpired()
+	return err
+}
+
+// an import gets a minute to insert its movies, anything still unfinished well after that has died with its process
+const staleImportJobAge = 10 * time.Minute
+
+func (app *application) failStaleImportJobs() error {
+	failed, err := app.models.ImportJobs.FailStale(staleImportJobAge)
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		app.logger.Info("failed stale import jobs", "count", failed)
+	}
+
+	return nil
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"greenlight/internal/data"
 	"greenlight/internal/mailer"
+	"greenlight/internal/validator"
 	"greenlight/internal/vcs"
 	"log/slog"
 	"os"
@@ -25,6 +28,13 @@ var (
 	version = vcs.Version()
 )
 
+// consts for the registration mode
+const (
+	registrationOpen       = "open"
+	registrationInviteOnly = "invite-only"
+	registrationClosed     = "closed"
+)
+
 // env args passed via cmd flags on app start
 type config struct {
 	port int
@@ -36,9 +46,11 @@ type config struct {
 		maxIdleTime  time.Duration
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps          float64
+		burst        int
+		enabled      bool
+		suggestRPS   float64
+		suggestBurst int
 	}
 	smtp struct {
 		host     string
@@ -50,14 +62,34 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	accounts struct {
+		deletionGracePeriod time.Duration
+		registration        string
+	}
+	impersonation struct {
+		ttl      time.Duration
+		readOnly bool
+	}
+	trash struct {
+		retention time.Duration
+	}
+	cursors struct {
+		secret string
+	}
+	idempotency struct {
+		ttl   time.Duration
+		lease time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     *sync.WaitGroup
+	config   config
+	logger   *slog.Logger
+	models   data.Models
+	mailer   *mailer.Mailer
+	wg       *sync.WaitGroup
+	shutdown chan struct{} // closed when the server starts shutting down
+	cursors  *data.CursorSigner
 }
 
 func main() {
@@ -80,6 +112,8 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.suggestRPS, "limiter-suggest-rps", 1, "Rate maximum title suggestion requests per second")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 3, "Rate limiter maximum burst for title suggestions")
 
 	// smtp settings
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
@@ -95,6 +129,36 @@ func main() {
 		return nil
 	})
 
+	// time users have to change their mind before their account is purged
+	flag.DurationVar(&cfg.accounts.deletionGracePeriod, "account-deletion-grace-period", 30*24*time.Hour, "Grace period before a deleted account is purged")
+
+	// open: anyone can register
+	// invite-only: accounts can only be created by accepting an invitation
+	// closed: no new accounts can be created
+	cfg.accounts.registration = registrationOpen
+	flag.Func("registration", "Registration mode (open|invite-only|closed)", func(val string) error {
+		if !validator.PermittedValue(val, registrationOpen, registrationInviteOnly, registrationClosed) {
+			return errors.New("must be one of open, invite-only or closed")
+		}
+
+		cfg.accounts.registration = val
+		return nil
+	})
+
+	// impersonation settings
+	flag.DurationVar(&cfg.impersonation.ttl, "impersonation-ttl", 15*time.Minute, "Lifetime of impersonation tokens")
+	flag.BoolVar(&cfg.impersonation.readOnly, "impersonation-read-only", true, "Block write requests while impersonating")
+
+	// how long deleted movies stay in the trash before they're purged
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Retention period for deleted movies")
+
+	// key used to sign pagination cursors
+	flag.StringVar(&cfg.cursors.secret, "cursor-secret", "", "Secret key for signing pagination cursors")
+
+	// how long responses are kept for replaying requests with the same Idempotency-Key
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "Lifetime of stored idempotent responses")
+	flag.DurationVar(&cfg.idempotency.lease, "idempotency-lease", time.Minute, "How long a request holds its Idempotency-Key before a retry may take it over")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -127,6 +191,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// cursors signed with a random key stop working when the server restarts
+	// and aren't accepted by other instances
+	if cfg.cursors.secret == "" {
+		cfg.cursors.secret = rand.Text()
+		logger.Warn("no cursor secret provided, using a random one")
+	}
+
 	// app metrics
 	expvar.NewString("version").Set(version)
 
@@ -148,11 +219,13 @@ func main() {
 	// declare an instance of application struct
 	// containing the config struct and the logger
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
-		wg:     &sync.WaitGroup{},
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer,
+		wg:       &sync.WaitGroup{},
+		shutdown: make(chan struct{}),
+		cursors:  data.NewCursorSigner([]byte(cfg.cursors.secret)),
 	}
 
 	if err = app.serve(); err != nil {
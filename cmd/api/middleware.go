@@ -44,6 +44,20 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		return next
 	}
 
+	return app.limitByIP(app.config.limiter.rps, app.config.limiter.burst, next)
+}
+
+// tighter limit for a single route, applied on top of rateLimit
+func (app *application) rateLimitRoute(rps float64, burst int, next http.HandlerFunc) http.HandlerFunc {
+	if !app.config.limiter.enabled {
+		return next
+	}
+
+	return app.limitByIP(rps, burst, next).ServeHTTP
+}
+
+// token bucket limiter per client ip
+func (app *application) limitByIP(rps float64, burst int, next http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
 		lastSeen time.Time
@@ -81,9 +95,8 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		mu.Lock()
 		if _, found := clients[ip]; !found {
 			// initialize a token based bucket rate limiter
-			// min 2 requests per second
-			// max 4 requests in a single burst
-			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			// allowing rps requests per second and at most burst requests at once
+			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
 		}
 
 		// update last seen time for the client
@@ -133,7 +146,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetUserByToken(data.ScopeAuthentication, token)
+		user, actorID, err := app.models.Users.GetUserByBearerToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -144,11 +157,69 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// suspended users are turned away no matter which route they hit
+		if user.IsSuspended() {
+			app.accountSuspendedResponse(w, r, user)
+			return
+		}
+
+		if actorID != 0 {
+			actor, err := app.impersonator(actorID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.internalServerErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if app.config.impersonation.readOnly && !isSafeMethod(r.Method) {
+				app.impersonationReadOnlyResponse(w, r)
+				return
+			}
+
+			// audit trail for everything done while impersonating
+			app.logger.Info("impersonated request", "method", r.Method, "uri", r.URL.RequestURI(), "user_id", user.ID, "actor_id", actor.ID)
+
+			r = app.contextSetRealUser(r, actor)
+		}
+
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// the admin behind an impersonation token must still be allowed to impersonate
+// when the request is made, not just when the token was issued
+func (app *application) impersonator(actorID int) (*data.User, error) {
+	actor, err := app.models.Users.Get(actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actor.Activated || actor.Status != data.StatusActive {
+		return nil, data.ErrRecordNotFound
+	}
+
+	permissions, err := app.models.Permissions.GetUserPermissions(actor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !permissions.Includes(data.PermissionUsersImpersonate) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return actor, nil
+}
+
+// methods that don't modify state
+func isSafeMethod(method string) bool {
+	return validator.PermittedValue(method, http.MethodGet, http.MethodHead, http.MethodOptions)
+}
+
 // we HandlerFunc so that we can wrap the handlers directly
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -176,6 +247,15 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 			return
 		}
 
+		switch {
+		case user.IsSuspended():
+			app.accountSuspendedResponse(w, r, user)
+			return
+		case user.IsPendingDeletion():
+			app.accountPendingDeletionResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 
@@ -217,6 +297,8 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		if origin != "" {
 			if slices.Contains(app.config.cors.trustedOrigins, origin) {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// lets browser clients read the validators needed for conditional requests
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Idempotent-Replayed")
 
 				// check if it is a preflight request
 				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
@@ -226,7 +308,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// since we are allowing Authorization headers
 					// we cannot set `Access-Control-Allow-Origin: *`
 					// otherwise we expose ourselves to distributed brute-force attacks
-					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key")
 
 					// cache preflight for 60 seconds before refresh
 					// default is 5s on Chrome and Mozilla
@@ -6,7 +6,9 @@ import (
 	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"net/http"
-	"strconv"
+	"slices"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -14,10 +16,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// so that they are visble to encoding/json package
 	// struct tags must match the incoming json request key
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string           `json:"title"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
+		Genres      []string         `json:"genres"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -28,64 +31,144 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// primitive types such as int, float, bool, struct and array must use `&` address operator if we need a pointer
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
+		ExternalIDs: input.ExternalIDs,
+	}
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
 	}
 
 	// since map, channel, interface and functions are implemented as pointer types we don't require `&` addresss operator if we need a pointer
 	// since we intend to reuse the validator in other handlers it's better to
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	// revisions are attributed to the real user
+	// so that changes made while impersonating stay traceable
+	err = app.models.Movies.Insert(movie, app.contextGetRealUser(r).ID)
 	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "a movie with one of these external ids already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	headers := make(http.Header)
+	headers := movieHeaders(movie)
 	// include location as a hint to let client know which URL they can find the newly created resource at
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
 }
 
+// movie list criteria shared by the endpoints that list movies
+// ?watchlisted= filters by the watchlist of the authenticated user
+func (app *application) readMovieFilter(r *http.Request, v *validator.Validator) data.MovieFilter {
+	qs := r.URL.Query()
+
+	filter := data.MovieFilter{
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresMode:    app.readString(qs, "genres_mode", data.GenresModeAll),
+		ExcludeGenres: app.readCSV(qs, "-genres", []string{}),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+		CreatedBefore: app.readTime(qs, "created_before", v),
+		PersonID:      app.readInt(qs, "person_id", 0, v),
+		PersonRole:    app.readString(qs, "role", ""),
+	}
+
+	if qs.Has("watchlisted") {
+		filter.WatchlistUser = app.contextGetUser(r).ID
+		filter.Watchlisted = app.readBool(qs, "watchlisted", true, v)
+	}
+
+	return filter
+}
+
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilter
 		data.Filters
+		Facets []string
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilter = app.readMovieFilter(r, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Sort = app.readString(qs, "sort", "id")
-	input.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.SortSafeList = []string{"id", "title", "year", "runtime", "rating_average", "rating_count", "-id", "-title", "-year", "-runtime", "-rating_average", "-rating_count"}
+
+	// an empty cursor asks for the first page in cursor mode
+	input.UseCursor = qs.Has("cursor")
+	input.Cursor = qs.Get("cursor")
+	input.Signer = app.cursors
+	input.SkipTotal = !app.readBool(qs, "include_total", true, v)
+
+	v.Check(!(input.UseCursor && qs.Has("page")), "page", "cannot be used together with cursor")
 
+	data.ValidateMovieFilter(v, input.MovieFilter)
+	data.ValidateFacets(v, input.Facets)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// facets count every movie matching the filter, not just the ones on this page
+	var facets map[string][]data.FacetCount
+	if len(input.Facets) > 0 {
+		facets, err = app.models.Movies.Facets(input.MovieFilter, input.Facets)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// lists get a weak ETag since the representation depends on
+	// the query as well as on the movies themselves
+	etag := moviesETag(movies, metadata, facets)
+	if notModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	if lastModified := moviesLastModified(movies); !lastModified.IsZero() {
+		headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, env, headers)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -98,6 +181,13 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	v := validator.New()
+	include := app.readIncludes(r.URL.Query(), []string{"revisions", "credits", "reviews"}, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
@@ -109,7 +199,58 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := movieHeaders(movie)
+	embedded := map[string]any{}
+
+	var (
+		credits []*data.Credit
+		reviews []*data.Review
+	)
+
+	if include.has("credits") {
+		credits, err = app.models.Credits.GetAllForMovie(int(movie.ID), "")
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		embedded["credits"] = credits
+	}
+
+	if include.has("reviews") {
+		// the latest visible reviews, the full list is paginated under /v1/movies/:id/reviews
+		reviews, _, err = app.models.Reviews.GetAllForMovie(int(movie.ID), false, data.Filters{Page: 1, PageSize: 20, Sort: "-created_at", SortSafeList: []string{"-created_at"}})
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		embedded["reviews"] = reviews
+	}
+
+	// credits and reviews change without bumping the movie's version
+	if credits != nil || reviews != nil {
+		headers.Set("ETag", movieRelatedETag(movie, credits, reviews))
+	}
+
+	// the client already holds the current version
+	if notModified(r, headers.Get("ETag")) {
+		for key, values := range headers {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if include.has("revisions") {
+		// revisions only change along with the movie's version so the ETag still holds
+		revisions, _, err := app.models.Revisions.GetAll(int(movie.ID), data.Filters{Page: 1, PageSize: 20, Sort: "-version", SortSafeList: []string{"-version"}})
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		embedded["revisions"] = revisions
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": embed(movie, embedded)}, headers)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -133,21 +274,113 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Round-trip locking
-	// a client sends a header indicating their current movie version and we check this against
-	// what we have in the database and throw an error if they don't match
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.Itoa(int(movie.Version)) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
+	if !app.checkMoviePreconditions(w, r, movie) {
+		return
+	}
+
+	switch requestMediaType(r) {
+	case mediaTypeMergePatch, mediaTypeJSONPatch:
+		if !app.applyMoviePatch(w, r, movie) {
+			return
+		}
+	default:
+		var input struct {
+			Title       *string           `json:"title"`        // will be nil if no corresponding key is provided in the JSON
+			Year        *int32            `json:"year"`         // likewise
+			Runtime     *data.Runtime     `json:"runtime"`      // likewise
+			Genres      []string          `json:"genres"`       // slices are implemented as pointers therefore their zero value is nil
+			ExternalIDs *data.ExternalIDs `json:"external_ids"` // replaces every external id when provided
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
 			return
 		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres // no need to dereference a slice
+		}
+
+		if input.ExternalIDs != nil {
+			movie.ExternalIDs = *input.ExternalIDs
+		}
+	}
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(movie, app.contextGetRealUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "a movie with one of these external ids already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, movieHeaders(movie))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// the body is the complete new state of the movie
+// fields that are left out are cleared rather than kept
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkMoviePreconditions(w, r, movie) {
+		return
 	}
 
 	var input struct {
-		Title   *string       `json:"title"`   // will be nil if no corresponding key is provided in the JSON
-		Year    *int32        `json:"year"`    // likewise
-		Runtime *data.Runtime `json:"runtime"` // likewise
-		Genres  []string      `json:"genres"`  // slices are implemented as pointers therefore their zero value is nil
+		Title       string           `json:"title"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
+		Genres      []string         `json:"genres"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -156,46 +389,186 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+	movie.ExternalIDs = input.ExternalIDs
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
 	}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	err = app.models.Movies.Update(movie, app.contextGetRealUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "a movie with one of these external ids already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres // no need to dereference a slice
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, movieHeaders(movie))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// creates or replaces the movie linked to an external id eg PUT /v1/movies/by-external/imdb/tt0133093
+// sending the same body again leaves the movie untouched
+func (app *application) upsertMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	source, externalID := params.ByName("source"), params.ByName("id")
+
+	var input struct {
+		Title       string           `json:"title"`
+		Year        int32            `json:"year"`
+		Runtime     data.Runtime     `json:"runtime"`
+		Genres      []string         `json:"genres"`
+		ExternalIDs data.ExternalIDs `json:"external_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateExternalID(v, source, externalID); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the id in the URL wins, the body may leave it out
+	if id := input.ExternalIDs.Get(source); id != "" && id != externalID {
+		v.AddError("external_ids."+source, "must match the id in the URL")
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	input.ExternalIDs.Set(source, externalID)
 
-	err = app.models.Movies.Update(movie)
+	var (
+		movie   *data.Movie
+		created bool
+		trashed bool
+	)
+
+	genres, ok := app.genreTaxonomy(w, r)
+	if !ok {
+		return
+	}
+
+	upsert := func(t *data.MovieTx) error {
+		movie, created, trashed = nil, false, false
+
+		existing, err := t.GetByExternalID(source, externalID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			movie = &data.Movie{}
+			created = true
+		case err != nil:
+			return err
+		case existing.DeletedAt != nil:
+			trashed = true
+			return nil
+		default:
+			movie = existing
+		}
+
+		replacement := data.Movie{
+			Title:       input.Title,
+			Year:        input.Year,
+			Runtime:     input.Runtime,
+			Genres:      input.Genres,
+			ExternalIDs: input.ExternalIDs,
+		}
+
+		if data.ValidateMovie(v, &replacement, genres); !v.Valid() {
+			return nil
+		}
+
+		if !created && sameMovieContent(movie, &replacement) {
+			return nil
+		}
+
+		movie.Title = replacement.Title
+		movie.Year = replacement.Year
+		movie.Runtime = replacement.Runtime
+		movie.Genres = replacement.Genres
+		movie.ExternalIDs = replacement.ExternalIDs
+
+		if created {
+			return t.Insert(movie)
+		}
+		return t.Update(movie)
+	}
+
+	err = app.models.Movies.Batch(app.contextGetRealUser(r).ID, upsert)
+	// when two requests create the same movie at once the loser's insert clashes on the external id
+	// running it again finds the winner's movie and updates it instead
+	if errors.Is(err, data.ErrDuplicateExternalID) && created {
+		err = app.models.Movies.Batch(app.contextGetRealUser(r).ID, upsert)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			// the body links the movie to an id another movie holds
+			v.AddError("external_ids", "a movie with one of these external ids already exists")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.internalServerErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	switch {
+	case trashed:
+		app.movieInTrashResponse(w, r)
+		return
+	case !v.Valid():
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	status := http.StatusOK
+	headers := movieHeaders(movie)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	}
+
+	err = app.writeJSON(w, r, status, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
 }
 
+// reports whether b would leave a unchanged
+func sameMovieContent(a, b *data.Movie) bool {
+	return a.Title == b.Title &&
+		a.Year == b.Year &&
+		a.Runtime == b.Runtime &&
+		slices.Equal(a.Genres, b.Genres) &&
+		a.ExternalIDs == b.ExternalIDs
+}
+
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIdParam(r)
 	if err != nil {
@@ -203,7 +576,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -214,10 +587,79 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.checkMoviePreconditions(w, r, movie) {
+		return
+	}
+
+	err = app.models.Movies.Delete(movie, app.contextGetRealUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// 204 No content is also fine here
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": fmt.Sprintf("movie with id: %d deleted successfully", id)}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": fmt.Sprintf("movie with id: %d deleted successfully", id)}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+
+}
+
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(input.Filters)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
 
+	movie, err := app.models.Movies.Restore(id, app.contextGetRealUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, movieHeaders(movie))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/patch"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// media types PATCH accepts on top of plain JSON
+const (
+	mediaTypeMergePatch = "application/merge-patch+json" // RFC 7396
+	mediaTypeJSONPatch  = "application/json-patch+json"  // RFC 6902
+)
+
+// the document patches are applied to
+// version can't be changed, it's there so that JSON Patch test operations can check it
+type moviePatchDocument struct {
+	Title       string           `json:"title"`
+	Year        int32            `json:"year"`
+	Runtime     data.Runtime     `json:"runtime"`
+	Genres      []string         `json:"genres"`
+	ExternalIDs data.ExternalIDs `json:"external_ids"`
+	Version     int32            `json:"version"`
+}
+
+// media type of the request body without parameters such as charset
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
+
+// applies a merge patch or JSON patch request body to movie
+// the error response has already been sent when it returns false
+func (app *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	// same limit as readJSON
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:       movie.Title,
+		Year:        movie.Year,
+		Runtime:     movie.Runtime,
+		Genres:      movie.Genres,
+		ExternalIDs: movie.ExternalIDs,
+		Version:     movie.Version,
+	})
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return false
+	}
+
+	var patched []byte
+
+	switch requestMediaType(r) {
+	case mediaTypeMergePatch:
+		patched, err = patch.Merge(doc, body)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("body contains badly-formed JSON"))
+			return false
+		}
+	default:
+		var ops []patch.Operation
+
+		err = json.Unmarshal(body, &ops)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("body must be a JSON array of patch operations"))
+			return false
+		}
+
+		patched, err = patch.Apply(doc, ops)
+		if err != nil {
+			switch {
+			case errors.Is(err, patch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			default:
+				app.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+			}
+			return false
+		}
+	}
+
+	var result moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"patch": fmt.Sprintf("must result in a valid movie: %v", err)})
+		return false
+	}
+
+	if result.Version != movie.Version {
+		app.failedValidationResponse(w, r, map[string]string{"version": "must not be changed"})
+		return false
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+	movie.ExternalIDs = result.ExternalIDs
+
+	return true
+}
@@ -16,26 +16,109 @@ func (app *application) routes() http.Handler {
 	// without this server would return plain text 405 response
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// httprouter doesn't allow a static segment in the same position as a named parameter
+	// eg /v1/movies/suggest and /v1/movies/:id, such routes go in here instead
+	// and are looked up before the main router
+	static := httprouter.New()
+
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
 
 	// improvement add metrics:read permission
 	router.Handler(http.MethodGet, "/v1/metrics", expvar.Handler())
 
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionMoviesWrite, app.createMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionMoviesWrite, app.idempotent(app.createMovieHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission(data.PermissionMoviesRead, app.listMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesRead, app.showMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.replaceMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/accounts/register", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/search/movies", app.requirePermission(data.PermissionMoviesRead, app.searchMoviesHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/movies/export", app.requirePermission(data.PermissionMoviesRead, app.exportMoviesHandler))
+	static.HandlerFunc(http.MethodPut, "/v1/movies/by-external/:source/:id", app.requirePermission(data.PermissionMoviesWrite, app.upsertMovieByExternalIDHandler))
+	static.HandlerFunc(http.MethodPost, "/v1/movies/batch", app.requirePermission(data.PermissionMoviesWrite, app.idempotent(app.batchMoviesHandler)))
+	static.HandlerFunc(http.MethodPost, "/v1/movies/import", app.requirePermission(data.PermissionMoviesWrite, app.importMoviesHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/movies/import/jobs/:id", app.requirePermission(data.PermissionMoviesWrite, app.showImportJobHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/movies/suggest", app.rateLimitRoute(app.config.limiter.suggestRPS, app.config.limiter.suggestBurst, app.requirePermission(data.PermissionMoviesRead, app.suggestMoviesHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission(data.PermissionMoviesRead, app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission(data.PermissionMoviesRead, app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermission(data.PermissionMoviesWrite, app.restoreMovieRevisionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission(data.PermissionMoviesRead, app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission(data.PermissionMoviesWrite, app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/credits/:credit_id", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission(data.PermissionMoviesRead, app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission(data.PermissionMoviesRead, app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission(data.PermissionMoviesRead, app.deleteMovieReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission(data.PermissionMoviesRead, app.listGenresHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission(data.PermissionMoviesRead, app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission(data.PermissionMoviesWrite, app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission(data.PermissionMoviesRead, app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.deletePersonHandler))
+
+	// reads don't require movies:read, readCollection decides who gets to see a collection
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission(data.PermissionMoviesRead, app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.showCollectionHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission(data.PermissionMoviesRead, app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission(data.PermissionMoviesRead, app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id/entries", app.listCollectionEntriesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections/:id/entries", app.requirePermission(data.PermissionMoviesRead, app.addCollectionEntryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id/entries/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.updateCollectionEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/entries/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.removeCollectionEntryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id/collaborators", app.requirePermission(data.PermissionMoviesRead, app.listCollectionCollaboratorsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/collaborators/:user_id", app.requirePermission(data.PermissionMoviesRead, app.addCollectionCollaboratorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/collaborators/:user_id", app.requirePermission(data.PermissionMoviesRead, app.removeCollectionCollaboratorHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/trash/movies", app.requirePermission(data.PermissionMoviesAdmin, app.listTrashedMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission(data.PermissionMoviesAdmin, app.restoreMovieHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/register", app.idempotent(app.registerUserHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/accounts/activate", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/accounts/password-reset", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/me", app.requireActivatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/me/export", app.requireActivatedUser(app.createDataExportHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/export/download", app.downloadDataExportHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/accounts/me", app.requireActivatedUser(app.deleteAccountHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/me/watchlist", app.requirePermission(data.PermissionMoviesRead, app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/me/watchlist", app.requirePermission(data.PermissionMoviesRead, app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/accounts/me/watchlist/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.moveWatchlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/accounts/me/watchlist/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/me/watched", app.requirePermission(data.PermissionMoviesRead, app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/me/watched", app.requirePermission(data.PermissionMoviesRead, app.createWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/accounts/me/watched/:id", app.requirePermission(data.PermissionMoviesRead, app.deleteWatchedHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/accounts/me/collections", app.requirePermission(data.PermissionMoviesRead, app.listMyCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/accounts/accept-invitation", app.acceptInvitationHandler)
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/accounts/login", app.createAuthenticationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/accounts/forgot-password", app.createPasswordResetTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/accounts/resend-activation-token", app.createActivationTokenHandler)
 
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/suspension", app.requirePermission(data.PermissionUsersAdmin, app.suspendUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/suspension", app.requirePermission(data.PermissionUsersAdmin, app.liftUserSuspensionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/impersonation", app.requirePermission(data.PermissionUsersImpersonate, app.createImpersonationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/reviews/:id/hidden", app.requirePermission(data.PermissionReviewsModerate, app.hideReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/reviews/:id/hidden", app.requirePermission(data.PermissionReviewsModerate, app.unhideReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/invitations", app.requirePermission(data.PermissionUsersAdmin, app.createInvitationHandler))
+
+	routers := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _, _ := static.Lookup(r.Method, r.URL.Path); handle != nil {
+			static.ServeHTTP(w, r)
+			return
+		}
+
+		router.ServeHTTP(w, r)
+	})
+
 	// apply middleware to all routes
-	// flow:- metrics -> recoverPanic -> enableCORS -> rateLimit -> authenticate -> requireActivatedUser
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// flow:- metrics -> recoverPanic -> enableCORS -> rateLimit -> authenticate -> rejectFieldsOnWrites -> requireActivatedUser
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.rejectFieldsOnWrites(routers))))))
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+func (app *application) searchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieSearch
+		data.MovieFilter
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Language = app.readString(qs, "lang", "simple")
+	input.MovieFilter = app.readMovieFilter(r, v)
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Sort = app.readString(qs, "sort", "relevance")
+	input.SortSafeList = []string{"relevance", "id", "title", "year", "runtime", "rating_average", "rating_count", "-id", "-title", "-year", "-runtime", "-rating_average", "-rating_count"}
+
+	// relevance always puts the best matches first so it doesn't mix with other keys
+	v.Check(input.Sort == "relevance" || !slices.Contains(strings.Split(input.Sort, ","), "relevance"), "sort", "relevance cannot be combined with other sort keys")
+
+	data.ValidateMovieSearch(v, input.MovieSearch)
+	data.ValidateMovieFilter(v, input.MovieFilter)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, metadata, err := app.models.Movies.Search(input.MovieSearch, input.MovieFilter, input.Filters)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": results, "metadata": metadata}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "prefix", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	data.ValidateSuggestPrefix(v, prefix)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be less than or equal to 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(prefix, limit)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
@@ -62,6 +62,8 @@ func (app *application) serve() error {
 		shutDownError <- srv.Shutdown(ctx) // instead of os.Exit(0)
 	}()
 
+	app.startJobs()
+
 	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
 
 	// calling shutdown on srv will return err `http.ErrServerClosed`
@@ -78,6 +80,9 @@ func (app *application) serve() error {
 		return err
 	}
 
+	// stop the periodic jobs so that they don't keep the WaitGroup busy
+	close(app.shutdown)
+
 	// Wait() prevents serve from returning to main()
 	// until Waitgroup's counter is zero.
 	// that ensures that all background processes run to completion
@@ -60,13 +60,36 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// only checked once the password matches so that
+	// the account status isn't leaked to anyone who knows the email
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+
+	// logging in during the deletion grace period cancels the deletion
+	if user.IsPendingDeletion() {
+		user.SetStatus(data.StatusActive, "", nil)
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
 	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -131,7 +154,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 
 	env := envelope{"message": "If we have an account associated with this E-Mail, you'll receive password reset instructions shortly."}
 
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -193,7 +216,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 
 	env := envelope{"message": "check your E-Mail for activation instructions"}
 
-	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
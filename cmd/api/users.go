@@ -9,6 +9,15 @@ import (
 )
 
 func (app application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	switch app.config.accounts.registration {
+	case registrationClosed:
+		app.registrationClosedResponse(w, r)
+		return
+	case registrationInviteOnly:
+		app.registrationInviteOnlyResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
@@ -25,6 +34,7 @@ func (app application) registerUserHandler(w http.ResponseWriter, r *http.Reques
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Status:    data.StatusActive,
 	}
 
 	err = user.Password.Set(input.Password)
@@ -80,7 +90,7 @@ func (app application) registerUserHandler(w http.ResponseWriter, r *http.Reques
 		}
 	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -136,7 +146,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
@@ -201,7 +211,7 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 
 	env := envelope{"message": "password reset was successful"}
 
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 	}
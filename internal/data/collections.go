@@ -0,0 +1,645 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+)
+
+var ErrDuplicateCollectionEntry = errors.New("duplicate collection entry")
+
+// consts for who gets to see a collection
+const (
+	CollectionPrivate  = "private"  // the owner and collaborators only
+	CollectionUnlisted = "unlisted" // anyone holding the share token as well
+	CollectionPublic   = "public"   // anyone, listed under /v1/collections
+)
+
+var CollectionVisibilities = []string{CollectionPrivate, CollectionUnlisted, CollectionPublic}
+
+// a list of movies curated by a user eg "Best of 1999"
+type Collection struct {
+	ID          int64     `json:"id"`
+	OwnerID     int       `json:"owner_id"`
+	OwnerName   string    `json:"owner_name"` // read only, taken from the owner
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Visibility  string    `json:"visibility"`
+	ShareToken  string    `json:"share_token,omitempty"` // only shown to those who can edit the collection
+	Version     int32     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// a movie in a collection
+type CollectionEntry struct {
+	CollectionID int64     `json:"collection_id"`
+	Position     int32     `json:"position"` // 1 is the top of the collection
+	Note         string    `json:"note,omitempty"`
+	AddedBy      *int      `json:"added_by,omitzero"` // nil once the user is deleted
+	AddedAt      time.Time `json:"added_at"`
+	Movie        *Movie    `json:"movie"`
+}
+
+// a user other than the owner who can edit the collection
+type Collaborator struct {
+	UserID  int       `json:"user_id"`
+	Name    string    `json:"name"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// keep in sync with scanCollection
+const collectionColumns = "id, owner_id, (SELECT name FROM users WHERE users.id = collections.owner_id), " +
+	"title, description, visibility, share_token, version, created_at, updated_at"
+
+// prefix holds destinations for any columns selected before collectionColumns eg count(*) OVER()
+func scanCollection(row interface{ Scan(...any) error }, collection *Collection, prefix ...any) error {
+	dest := append(prefix,
+		&collection.ID,
+		&collection.OwnerID,
+		&collection.OwnerName,
+		&collection.Title,
+		&collection.Description,
+		&collection.Visibility,
+		&collection.ShareToken,
+		&collection.Version,
+		&collection.CreatedAt,
+		&collection.UpdatedAt,
+	)
+
+	return row.Scan(dest...)
+}
+
+func ValidateCollection(v *validator.Validator, c *Collection) {
+	v.Check(c.Title != "", "title", "must be provided")
+	v.Check(len(c.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(c.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+	v.Check(validator.PermittedValue(c.Visibility, CollectionVisibilities...), "visibility", "must be one of private, unlisted or public")
+}
+
+func ValidateCollectionNote(v *validator.Validator, note string) {
+	v.Check(len(note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// a fresh token invalidates links handed out with the old one
+func NewShareToken() string {
+	return rand.Text()
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `
+		INSERT INTO collections (owner_id, title, description, visibility, share_token)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, (SELECT name FROM users WHERE id = $1), version, created_at, updated_at
+	`
+
+	args := []any{collection.OwnerID, collection.Title, collection.Description, collection.Visibility, collection.ShareToken}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&collection.ID,
+		&collection.OwnerName,
+		&collection.Version,
+		&collection.CreatedAt,
+		&collection.UpdatedAt,
+	)
+}
+
+// returns the collection whatever its visibility, it's up to the caller who gets to see it
+func (m CollectionModel) Get(id int) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM collections
+		WHERE id = $1
+	`, collectionColumns)
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCollection(m.DB.QueryRowContext(ctx, query, id), &collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// public collections only, title matches whole words the same way the movie title filter does
+func (m CollectionModel) GetAllPublic(title string, filters Filters) ([]*Collection, Metadata, error) {
+	var w where
+	w.add("visibility = " + w.arg(CollectionPublic))
+	if title != "" {
+		w.add("to_tsvector('simple', title) @@ plainto_tsquery('simple', " + w.arg(title) + ")")
+	}
+
+	return m.getAll(w, filters)
+}
+
+// collections the user owns or collaborates on
+func (m CollectionModel) GetAllEditable(userID int, filters Filters) ([]*Collection, Metadata, error) {
+	var w where
+	w.add(editableBy(&w, userID))
+
+	return m.getAll(w, filters)
+}
+
+// condition matching the collections the user can edit
+func editableBy(w *where, userID int) string {
+	arg := w.arg(userID)
+	return fmt.Sprintf("(owner_id = %[1]s OR id IN (SELECT collection_id FROM collection_collaborators WHERE user_id = %[1]s))", arg)
+}
+
+func (m CollectionModel) getAll(w where, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, collectionColumns, w.String(), filters.orderBy(false), w.arg(filters.limit()), w.arg(filters.offset()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+
+		err := scanCollection(rows, &collection, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// every collection the user owns or collaborates on
+func (m CollectionModel) GetAllForUser(userID int) ([]*Collection, error) {
+	var w where
+	w.add(editableBy(&w, userID))
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM collections
+		WHERE %s
+		ORDER BY id
+	`, collectionColumns, w.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+
+		err := scanCollection(rows, &collection)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET title = $1, description = $2, visibility = $3, share_token = $4, updated_at = NOW(), version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING updated_at, version
+	`
+
+	args := []any{
+		collection.Title,
+		collection.Description,
+		collection.Visibility,
+		collection.ShareToken,
+		collection.ID,
+		collection.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.UpdatedAt, &collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// entries and collaborators go along with the collection
+func (m CollectionModel) Delete(id int64) error {
+	query := `
+		DELETE FROM collections
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// reports whether the user owns or collaborates on the collection
+func (m CollectionModel) CanEdit(collection *Collection, userID int) (bool, error) {
+	if collection.OwnerID == userID {
+		return true, nil
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM collection_collaborators
+			WHERE collection_id = $1
+			AND user_id = $2
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, collection.ID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (m CollectionModel) GetCollaborators(collectionID int64) ([]*Collaborator, error) {
+	query := `
+		SELECT users.id, users.name, collection_collaborators.added_at
+		FROM collection_collaborators
+		INNER JOIN users ON users.id = collection_collaborators.user_id
+		WHERE collection_collaborators.collection_id = $1
+		ORDER BY collection_collaborators.added_at, users.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []*Collaborator{}
+	for rows.Next() {
+		var collaborator Collaborator
+
+		err := rows.Scan(&collaborator.UserID, &collaborator.Name, &collaborator.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, &collaborator)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collaborators, nil
+}
+
+// adding someone who already collaborates leaves them as they are
+func (m CollectionModel) AddCollaborator(collectionID int64, user *User) (*Collaborator, error) {
+	query := `
+		WITH inserted AS (
+			INSERT INTO collection_collaborators (collection_id, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT (collection_id, user_id) DO NOTHING
+			RETURNING added_at
+		)
+		SELECT added_at FROM inserted
+		UNION ALL
+		SELECT added_at FROM collection_collaborators WHERE collection_id = $1 AND user_id = $2
+		LIMIT 1
+	`
+
+	collaborator := &Collaborator{UserID: user.ID, Name: user.Name}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, collectionID, user.ID).Scan(&collaborator.AddedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return collaborator, nil
+}
+
+// the entries they added stay in the collection
+func (m CollectionModel) RemoveCollaborator(collectionID int64, userID int) error {
+	query := `
+		DELETE FROM collection_collaborators
+		WHERE collection_id = $1
+		AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, collectionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type CollectionEntryModel struct {
+	DB *sql.DB
+}
+
+var collectionOrder = orderedMovies{table: "collection_entries", owner: "collection_id"}
+
+// runs fn on the locked collection in a single transaction so that positions stay consecutive
+// NO KEY UPDATE doesn't block inserts referencing the collection elsewhere
+func (m CollectionEntryModel) transaction(collectionID int64, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT id FROM collections WHERE id = $1 FOR NO KEY UPDATE", collectionID)
+	if err != nil {
+		return err
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// keep in sync with scanCollectionEntry, selected from collection_entries joined with movies
+const collectionEntryColumns = "collection_entries.collection_id, collection_entries.position, collection_entries.note, " +
+	"collection_entries.added_by, collection_entries.added_at"
+
+// prefix holds destinations for any columns selected before collectionEntryColumns eg count(*) OVER()
+func scanCollectionEntry(row interface{ Scan(...any) error }, entry *CollectionEntry, prefix ...any) error {
+	dest := append(prefix,
+		&entry.CollectionID,
+		&entry.Position,
+		&entry.Note,
+		&entry.AddedBy,
+		&entry.AddedAt,
+	)
+
+	return scanMovie(row, entry.Movie, dest...)
+}
+
+// movies in the trash keep their place but aren't listed
+func (m CollectionEntryModel) GetAll(collectionID int64, filters Filters) ([]*CollectionEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s, %s
+		FROM collection_entries
+		INNER JOIN movies ON movies.id = collection_entries.movie_id
+		WHERE collection_entries.collection_id = $1
+		AND deleted_at IS NULL
+		ORDER BY %s
+		LIMIT $2 OFFSET $3
+	`, collectionEntryColumns, movieColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*CollectionEntry{}
+	for rows.Next() {
+		entry := CollectionEntry{Movie: &Movie{}}
+
+		err := scanCollectionEntry(rows, &entry, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// the entries the user added to any collection, movies in the trash included
+func (m CollectionEntryModel) GetAllAddedBy(userID int) ([]*CollectionEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM collection_entries
+		INNER JOIN movies ON movies.id = collection_entries.movie_id
+		WHERE collection_entries.added_by = $1
+		ORDER BY collection_entries.collection_id, collection_entries.position
+	`, collectionEntryColumns, movieColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*CollectionEntry{}
+	for rows.Next() {
+		entry := CollectionEntry{Movie: &Movie{}}
+
+		err := scanCollectionEntry(rows, &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// returns the entry even if its movie is in the trash
+func (m CollectionEntryModel) Get(collectionID int64, movieID int) (*CollectionEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s
+		FROM collection_entries
+		INNER JOIN movies ON movies.id = collection_entries.movie_id
+		WHERE collection_entries.collection_id = $1
+		AND collection_entries.movie_id = $2
+	`, collectionEntryColumns, movieColumns)
+
+	entry := CollectionEntry{Movie: &Movie{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCollectionEntry(m.DB.QueryRowContext(ctx, query, collectionID, movieID), &entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// puts the movie at the position, the movies from there on move down one place
+// the position is clamped to the collection, 0 adds the movie to the end
+func (m CollectionEntryModel) Add(entry *CollectionEntry) error {
+	return m.transaction(entry.CollectionID, func(ctx context.Context, tx *sql.Tx) error {
+		position, err := collectionOrder.makeRoom(ctx, tx, entry.CollectionID, entry.Position)
+		if err != nil {
+			return err
+		}
+		entry.Position = position
+
+		query := `
+			INSERT INTO collection_entries (collection_id, movie_id, position, note, added_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING added_at
+		`
+
+		args := []any{entry.CollectionID, entry.Movie.ID, entry.Position, entry.Note, entry.AddedBy}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "collection_entries_pkey"`:
+				return ErrDuplicateCollectionEntry
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// moves the entry to its position and saves its note, the movies in between shift by one place
+// the position is clamped to the collection
+func (m CollectionEntryModel) Update(entry *CollectionEntry) error {
+	return m.transaction(entry.CollectionID, func(ctx context.Context, tx *sql.Tx) error {
+		position, err := collectionOrder.move(ctx, tx, entry.CollectionID, entry.Movie.ID, entry.Position)
+		if err != nil {
+			return err
+		}
+		entry.Position = position
+
+		query := `
+			UPDATE collection_entries
+			SET note = $1
+			WHERE collection_id = $2
+			AND movie_id = $3
+		`
+
+		_, err = tx.ExecContext(ctx, query, entry.Note, entry.CollectionID, entry.Movie.ID)
+		return err
+	})
+}
+
+// the movies below the removed one move up one place
+func (m CollectionEntryModel) Remove(collectionID int64, movieID int) error {
+	return m.transaction(collectionID, func(ctx context.Context, tx *sql.Tx) error {
+		return collectionOrder.remove(ctx, tx, collectionID, int64(movieID))
+	})
+}
@@ -0,0 +1,253 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+	ErrPersonNotFound  = errors.New("person not found")
+)
+
+// consts for the part a person played in a movie
+const (
+	CreditRoleDirector = "director"
+	CreditRoleWriter   = "writer"
+	CreditRoleActor    = "actor"
+)
+
+// also the order credits are listed in
+var CreditRoles = []string{CreditRoleDirector, CreditRoleWriter, CreditRoleActor}
+
+// links a person to a movie they worked on
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name"` // read only, taken from the person
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`    // actors only
+	BillingOrder int32  `json:"billing_order,omitzero"` // actors only, 1 is billed first and zero means unbilled
+	Version      int32  `json:"version"`
+}
+
+// selected from credits joined with people, keep in sync with scanCredit
+const creditColumns = "credits.id, credits.movie_id, credits.person_id, people.name, credits.role, " +
+	"credits.character, COALESCE(credits.billing_order, 0), credits.version"
+
+func scanCredit(row interface{ Scan(...any) error }, credit *Credit) error {
+	return row.Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.PersonName,
+		&credit.Role,
+		&credit.Character,
+		&credit.BillingOrder,
+		&credit.Version,
+	)
+}
+
+func ValidateCredit(v *validator.Validator, c *Credit) {
+	v.Check(c.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.PermittedValue(c.Role, CreditRoles...), "role", "must be one of director, writer or actor")
+
+	if c.Role == CreditRoleActor {
+		v.Check(c.Character != "", "character", "must be provided for actors")
+		v.Check(len(c.Character) <= 500, "character", "must not be more than 500 bytes long")
+		v.Check(c.BillingOrder >= 0, "billing_order", "must be a positive integer")
+	} else {
+		v.Check(c.Character == "", "character", "must only be set for actors")
+		v.Check(c.BillingOrder == 0, "billing_order", "must only be set for actors")
+	}
+}
+
+// maps constraint violations on insert and update to the errors callers can act on
+func creditError(err error) error {
+	switch {
+	case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_person_role_character_key"`:
+		return ErrDuplicateCredit
+	case err.Error() == `pq: insert or update on table "credits" violates foreign key constraint "credits_person_id_fkey"`:
+		return ErrPersonNotFound
+	default:
+		return err
+	}
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// credits of a movie, directors first then writers then actors in billing order
+// role narrows them down to one role when it isn't empty
+func (m CreditModel) GetAllForMovie(movieID int, role string) ([]*Credit, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM credits
+		INNER JOIN people ON people.id = credits.person_id
+		WHERE credits.movie_id = $1
+		AND (credits.role = $2 OR $2 = '')
+		ORDER BY array_position($3::text[], credits.role), credits.billing_order NULLS LAST, credits.id
+	`, creditColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, role, pq.Array(CreditRoles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+
+		err := scanCredit(rows, &credit)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// credits are only reachable through their movie so the movie id has to match as well
+func (m CreditModel) Get(movieID, id int) (*Credit, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM credits
+		INNER JOIN people ON people.id = credits.person_id
+		WHERE credits.movie_id = $1
+		AND credits.id = $2
+	`, creditColumns)
+
+	var credit Credit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanCredit(m.DB.QueryRowContext(ctx, query, movieID, id), &credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &credit, nil
+}
+
+// the person's name is read back along with the generated columns
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO credits (movie_id, person_id, role, character, billing_order)
+			VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+			RETURNING id, person_id, version
+		)
+		SELECT inserted.id, people.name, inserted.version
+		FROM inserted
+		INNER JOIN people ON people.id = inserted.person_id
+	`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.PersonName, &credit.Version)
+	if err != nil {
+		return creditError(err)
+	}
+
+	return nil
+}
+
+func (m CreditModel) Update(credit *Credit) error {
+	query := `
+		WITH updated AS (
+			UPDATE credits
+			SET person_id = $1, role = $2, character = $3, billing_order = NULLIF($4, 0), version = version + 1
+			WHERE id = $5 AND movie_id = $6 AND version = $7
+			RETURNING person_id, version
+		)
+		SELECT people.name, updated.version
+		FROM updated
+		INNER JOIN people ON people.id = updated.person_id
+	`
+
+	args := []any{
+		credit.PersonID,
+		credit.Role,
+		credit.Character,
+		credit.BillingOrder,
+		credit.ID,
+		credit.MovieID,
+		credit.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.PersonName, &credit.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return creditError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m CreditModel) Delete(movieID, id int) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM credits
+		WHERE movie_id = $1
+		AND id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
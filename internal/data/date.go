@@ -0,0 +1,48 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+const dateLayout = "2006-01-02"
+
+// calendar date without a time of day, written as "2006-01-02" in JSON
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(dateLayout))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(dateLayout, unquotedJSONValue)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	d.Time = t
+
+	return nil
+}
+
+// the date as Postgres expects it for a DATE column
+// passing the time.Time instead would shift it by the session's time zone
+func (d Date) String() string {
+	return d.Format(dateLayout)
+}
+
+// the current date in UTC
+func Today() Date {
+	now := time.Now().UTC()
+	return Date{time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+}
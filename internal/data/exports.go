@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// everything we hold about a user
+// handed out to satisfy GDPR access requests
+type UserArchive struct {
+	GeneratedAt       time.Time          `json:"generated_at"`
+	Profile           *User              `json:"profile"`
+	Permissions       Permissions        `json:"permissions"`
+	Tokens            []TokenMetadata    `json:"tokens"`
+	Reviews           []*Review          `json:"reviews"`
+	Watchlist         []*WatchlistItem   `json:"watchlist"`
+	Watched           []*WatchedEntry    `json:"watched"`
+	Collections       []*Collection      `json:"collections"`        // owned or collaborated on
+	CollectionEntries []*CollectionEntry `json:"collection_entries"` // added by the user to any collection
+}
+
+type UserExport struct {
+	UserID    int
+	Archive   []byte
+	CreatedAt time.Time
+	Expiry    time.Time
+}
+
+type ExportModel struct {
+	DB *sql.DB
+}
+
+// a user only ever has a single export
+// requesting a new one replaces the previous archive
+func (m ExportModel) Upsert(export *UserExport) error {
+	query := `
+		INSERT INTO user_exports (user_id, archive, expiry)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET archive = EXCLUDED.archive, expiry = EXCLUDED.expiry, created_at = NOW()
+		RETURNING created_at
+	`
+
+	args := []any{export.UserID, export.Archive, export.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&export.CreatedAt)
+}
+
+func (m ExportModel) Get(userID int) (*UserExport, error) {
+	query := `
+		SELECT user_id, archive, created_at, expiry
+		FROM user_exports
+		WHERE user_id = $1
+		AND expiry > $2
+	`
+
+	var export UserExport
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, time.Now()).Scan(
+		&export.UserID,
+		&export.Archive,
+		&export.CreatedAt,
+		&export.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+func (m ExportModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM user_exports
+		WHERE expiry <= $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
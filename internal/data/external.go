@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateExternalID = errors.New("duplicate external id")
+
+// consts for the catalogues a movie can be linked to
+const (
+	ExternalSourceIMDb     = "imdb"
+	ExternalSourceTMDb     = "tmdb"
+	ExternalSourceWikidata = "wikidata"
+)
+
+var ExternalSources = []string{ExternalSourceIMDb, ExternalSourceTMDb, ExternalSourceWikidata}
+
+// column holding each source's id, every one of them has a unique constraint
+var externalIDColumns = map[string]string{
+	ExternalSourceIMDb:     "imdb_id",
+	ExternalSourceTMDb:     "tmdb_id",
+	ExternalSourceWikidata: "wikidata_id",
+}
+
+var externalIDRX = map[string]*regexp.Regexp{
+	ExternalSourceIMDb:     regexp.MustCompile(`^tt\d{7,10}$`),
+	ExternalSourceTMDb:     regexp.MustCompile(`^[1-9]\d{0,9}$`),
+	ExternalSourceWikidata: regexp.MustCompile(`^Q[1-9]\d{0,11}$`),
+}
+
+// IMDb uses a different prefix for people, the other sources share one id space
+var personExternalIDRX = map[string]*regexp.Regexp{
+	ExternalSourceIMDb:     regexp.MustCompile(`^nm\d{7,10}$`),
+	ExternalSourceTMDb:     externalIDRX[ExternalSourceTMDb],
+	ExternalSourceWikidata: externalIDRX[ExternalSourceWikidata],
+}
+
+// identifiers of the movie in other catalogues, empty when unknown
+type ExternalIDs struct {
+	IMDb     string `json:"imdb,omitempty"`     // eg tt0133093
+	TMDb     string `json:"tmdb,omitempty"`     // eg 603
+	Wikidata string `json:"wikidata,omitempty"` // eg Q83495
+}
+
+func (e ExternalIDs) Get(source string) string {
+	switch source {
+	case ExternalSourceIMDb:
+		return e.IMDb
+	case ExternalSourceTMDb:
+		return e.TMDb
+	case ExternalSourceWikidata:
+		return e.Wikidata
+	}
+
+	return ""
+}
+
+func (e *ExternalIDs) Set(source, id string) {
+	switch source {
+	case ExternalSourceIMDb:
+		e.IMDb = id
+	case ExternalSourceTMDb:
+		e.TMDb = id
+	case ExternalSourceWikidata:
+		e.Wikidata = id
+	}
+}
+
+// validates a movie's id in one of the external sources
+func ValidateExternalID(v *validator.Validator, source, id string) {
+	validateExternalID(v, externalIDRX, source, id)
+}
+
+func validateExternalID(v *validator.Validator, patterns map[string]*regexp.Regexp, source, id string) {
+	if !validator.PermittedValue(source, ExternalSources...) {
+		v.AddError("source", "must be one of imdb, tmdb or wikidata")
+		return
+	}
+
+	v.Check(validator.Matches(id, patterns[source]), "external_ids."+source, fmt.Sprintf("must be a valid %s id", source))
+}
+
+func validateExternalIDs(v *validator.Validator, patterns map[string]*regexp.Regexp, e ExternalIDs) {
+	for _, source := range ExternalSources {
+		if id := e.Get(source); id != "" {
+			validateExternalID(v, patterns, source, id)
+		}
+	}
+}
+
+// maps unique constraint violations on the external id columns of table to ErrDuplicateExternalID
+func externalIDError(table string, err error) error {
+	for _, column := range externalIDColumns {
+		if err.Error() == fmt.Sprintf(`pq: duplicate key value violates unique constraint "%s_%s_key"`, table, column) {
+			return ErrDuplicateExternalID
+		}
+	}
+
+	return err
+}
+
+// locks the movie linked to the external id until the transaction ends
+// movies in the trash are returned as well since they still hold on to their ids
+func (t *MovieTx) GetByExternalID(source, id string) (*Movie, error) {
+	column, ok := externalIDColumns[source]
+	if !ok || id == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movies
+		WHERE %s = $1
+		FOR UPDATE
+	`, movieColumns, column)
+
+	var movie Movie
+
+	err := scanMovie(t.tx.QueryRowContext(t.ctx, query, id), &movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// the ids out of ids that a movie, trashed or not, already holds in the source
+func (m MovieModel) TakenExternalIDs(source string, ids []string) ([]string, error) {
+	column, ok := externalIDColumns[source]
+	if !ok || len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %[1]s
+		FROM movies
+		WHERE %[1]s = ANY($1)
+	`, column)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var taken []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		taken = append(taken, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return taken, nil
+}
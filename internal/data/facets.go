@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/validator"
+	"strings"
+	"time"
+)
+
+// facets a movie list can be broken down by
+var MovieFacets = []string{"genres", "year", "runtime"}
+
+// number of movies with a facet value
+// year values are decades eg 1990 and runtime values are ranges of minutes eg 90-119
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		if !validator.PermittedValue(facet, MovieFacets...) {
+			v.AddError("facets", fmt.Sprintf("must be a comma separated list of %s", strings.Join(MovieFacets, ",")))
+			break
+		}
+	}
+
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// one SELECT per facet, each returning the facet name, value, count and ordinal of the value
+// genres are ordered by count while buckets keep their natural order
+var movieFacetQueries = map[string]string{
+	"genres": `
+		SELECT 'genres', genre, count(*), -count(*)
+		FROM matches, unnest(genres) AS genre
+		GROUP BY genre
+	`,
+	"year": `
+		SELECT 'year', (year / 10 * 10)::text, count(*), year / 10 * 10
+		FROM matches
+		GROUP BY year / 10 * 10
+	`,
+	"runtime": `
+		SELECT 'runtime', bucket, count(*), min(runtime)
+		FROM (
+			SELECT runtime, CASE
+				WHEN runtime < 90 THEN '0-89'
+				WHEN runtime < 120 THEN '90-119'
+				WHEN runtime < 150 THEN '120-149'
+				ELSE '150+'
+			END AS bucket
+			FROM matches
+		) AS buckets
+		GROUP BY bucket
+	`,
+}
+
+// counts for every facet of the movies matching filter
+// the matching movies are read once into a materialized CTE which each facet then aggregates
+func (m MovieModel) Facets(filter MovieFilter, facets []string) (map[string][]FacetCount, error) {
+	result := make(map[string][]FacetCount, len(facets))
+	if len(facets) == 0 {
+		return result, nil
+	}
+
+	var w where
+	filter.where(&w)
+
+	selects := make([]string, len(facets))
+	for i, facet := range facets {
+		selects[i] = movieFacetQueries[facet]
+		result[facet] = []FacetCount{}
+	}
+
+	query := fmt.Sprintf(`
+		WITH matches AS MATERIALIZED (
+			SELECT genres, year, runtime
+			FROM movies
+			WHERE %s
+		)
+		SELECT facet, value, count
+		FROM (%s) AS facets (facet, value, count, ordinal)
+		ORDER BY facet, ordinal, value
+	`, w.String(), strings.Join(selects, "UNION ALL"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var facet string
+		var count FacetCount
+
+		err := rows.Scan(&facet, &count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		result[facet] = append(result[facet], count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -5,6 +5,7 @@ import (
 	"greenlight/internal/validator"
 	"slices"
 	"strings"
+	"time"
 )
 
 type Filters struct {
@@ -12,29 +13,167 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// keyset pagination, Cursor is empty when fetching the first page
+	UseCursor bool
+	Cursor    string
+	Signer    *CursorSigner
+	// skips counting every matching row, which gets slow on large tables
+	SkipTotal bool
 }
 
-func (f Filters) sortColumn() string {
-	if slices.Contains(f.SortSafeList, f.Sort) {
-		return strings.TrimPrefix(f.Sort, "-")
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+// OFFSET records to skip
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// sort keys in the order the client listed them eg -year,title
+// Sort is checked against SortSafeList by ValidateFilters, the panic
+// guards against column names reaching the query unchecked
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	for _, s := range strings.Split(f.Sort, ",") {
+		if !slices.Contains(f.SortSafeList, s) {
+			panic("unsafe sort parameter: " + s)
+		}
+
+		keys = append(keys, sortKey{column: strings.TrimPrefix(s, "-"), desc: strings.HasPrefix(s, "-")})
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+
+	return keys
 }
 
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// sort keys followed by id unless the client already sorts by it
+// so that rows with equal sort values always come back in the same order
+func (f Filters) orderKeys() []sortKey {
+	keys := f.sortKeys()
+	for _, key := range keys {
+		if key.column == "id" {
+			return keys
+		}
 	}
-	return "ASC"
+
+	return append(keys, sortKey{column: "id"})
 }
 
-func (f Filters) limit() int {
-	return f.PageSize
+// the cursor the client sent, nil when fetching the first page
+// it has already been checked by ValidateFilters
+func (f Filters) cursor() *Cursor {
+	if f.Cursor == "" {
+		return nil
+	}
+
+	cursor, err := f.Signer.Decode(f.Cursor)
+	if err != nil {
+		return nil
+	}
+
+	return cursor
 }
 
-// OFFSET records to skip
-func (f Filters) offset() int {
-	return (f.Page - 1) * f.PageSize
+// ORDER BY clause for the sort keys
+// reverse flips every key so that keyset pagination can LIMIT the rows right before a backward cursor
+func (f Filters) orderBy(reverse bool) string {
+	keys := f.orderKeys()
+
+	clauses := make([]string, len(keys))
+	for i, key := range keys {
+		desc := key.desc != reverse
+
+		clauses[i] = key.column + " ASC"
+		if desc {
+			clauses[i] = key.column + " DESC"
+		}
+	}
+
+	return strings.Join(clauses, ", ")
+}
+
+// WHERE condition that selects the rows after the cursor in sort order
+// or before it for backward cursors
+// for sort keys a, b this expands to (a > $1) OR (a = $1 AND b > $2) OR (a = $1 AND b = $2 AND id > $3)
+func (f Filters) keysetCondition(cursor *Cursor, w *where) string {
+	keys := f.orderKeys()
+
+	// the id tiebreak comes after the sort values when it isn't one of the sort keys
+	placeholders := make([]string, len(keys))
+	for i := range keys {
+		if i < len(cursor.Values) {
+			placeholders[i] = w.arg(cursor.Values[i])
+		} else {
+			placeholders[i] = w.arg(cursor.ID)
+		}
+	}
+
+	alternatives := make([]string, len(keys))
+	for i, key := range keys {
+		conditions := make([]string, 0, i+1)
+
+		for j := range i {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", keys[j].column, placeholders[j]))
+		}
+
+		operator := ">"
+		if key.desc != cursor.Backward {
+			operator = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", key.column, operator, placeholders[i]))
+
+		alternatives[i] = "(" + strings.Join(conditions, " AND ") + ")"
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// items holds up to PageSize+1 rows fetched in keyset order, the extra row tells us there's more
+// position returns the sort values and id of a row so that cursors can point at it
+func keysetPage[T any](items []T, f Filters, cursor *Cursor, position func(T) Cursor) ([]T, Metadata) {
+	backward := cursor != nil && cursor.Backward
+
+	more := len(items) > f.PageSize
+	if more {
+		items = items[:f.PageSize]
+	}
+
+	// backward pages are fetched in reverse order
+	if backward {
+		slices.Reverse(items)
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+	if len(items) == 0 {
+		return items, metadata
+	}
+
+	// moving forward there's a next page if we fetched the extra row and a previous one unless this is the first page
+	// moving backward it's the other way around
+	hasNext, hasPrevious := more, cursor != nil
+	if backward {
+		hasNext, hasPrevious = true, more
+	}
+
+	if hasNext {
+		next := position(items[len(items)-1])
+		next.Sort = f.Sort
+		metadata.NextCursor = f.Signer.Encode(next)
+	}
+
+	if hasPrevious {
+		previous := position(items[0])
+		previous.Sort = f.Sort
+		previous.Backward = true
+		metadata.PrevCursor = f.Signer.Encode(previous)
+	}
+
+	return items, metadata
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -42,15 +181,104 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page <= 10_000_000, "page", "must be less than or equal to 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be less than or equal to 100")
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", fmt.Sprintf("invalid sort value. use %v", strings.Join(f.SortSafeList, ",")))
+	ValidateSort(v, f)
+
+	// the cursor can only be checked against a valid sort
+	if f.UseCursor && f.Cursor != "" && v.Errors["sort"] == "" {
+		cursor, err := f.Signer.Decode(f.Cursor)
+		switch {
+		case err != nil:
+			v.AddError("cursor", "must be a cursor returned by a previous request")
+		case cursor.Sort != f.Sort || len(cursor.Values) != len(f.sortKeys()):
+			v.AddError("cursor", "was issued for a different sort order")
+		}
+	}
+}
+
+// genre match modes
+const (
+	GenresModeAll  = "all"  // movies with every genre
+	GenresModeAny  = "any"  // movies with at least one of the genres
+	GenresModeNone = "none" // movies with none of the genres
+)
+
+// criteria for narrowing down the movie list
+// zero values mean the criterion isn't applied
+type MovieFilter struct {
+	Title         string
+	Genres        []string
+	GenresMode    string
+	ExcludeGenres []string
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	PersonID      int    // movies the person is credited on
+	PersonRole    string // narrows PersonID down to one role
+	WatchlistUser int    // applies Watchlisted to this user's watchlist
+	Watchlisted   bool   // movies on the watchlist when true, movies not on it when false
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	v.Check(validator.PermittedValue(f.GenresMode, GenresModeAll, GenresModeAny, GenresModeNone), "genres_mode", "must be one of all, any or none")
+
+	currentYear := time.Now().Year()
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888 && f.YearMin <= currentYear, "year_min", fmt.Sprintf("must be between 1888 and %d", currentYear))
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888 && f.YearMax <= currentYear, "year_max", fmt.Sprintf("must be between 1888 and %d", currentYear))
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	if f.RuntimeMin > 0 && f.RuntimeMax > 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() {
+		v.Check(f.CreatedAfter.Before(f.CreatedBefore), "created_after", "must be before created_before")
+	}
+
+	v.Check(f.PersonID >= 0, "person_id", "must be a positive integer")
+	if f.PersonRole != "" {
+		v.Check(f.PersonID > 0, "role", "can only be used together with person_id")
+		v.Check(validator.PermittedValue(f.PersonRole, CreditRoles...), "role", "must be one of director, writer or actor")
+	}
+}
+
+// sort is a comma separated list of keys from SortSafeList eg -year,title
+// exported for endpoints that sort without paginating
+func ValidateSort(v *validator.Validator, f Filters) {
+	keys := strings.Split(f.Sort, ",")
+	v.Check(len(keys) <= 3, "sort", "must not contain more than 3 keys")
+
+	columns := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !validator.PermittedValue(key, f.SortSafeList...) {
+			v.AddError("sort", fmt.Sprintf("invalid sort value. use a comma separated list of %v", strings.Join(f.SortSafeList, ",")))
+			return
+		}
+
+		columns = append(columns, strings.TrimPrefix(key, "-"))
+	}
+
+	v.Check(validator.Unique(columns), "sort", "must not contain the same column more than once")
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitzero"`
-	PageSize     int `json:"page_size,omitzero"`
-	FirstPage    int `json:"first_page,omitzero"`
-	LastPage     int `json:"last_page,omitzero"`
-	TotalRecords int `json:"total_record,omitzero"`
+	CurrentPage  int    `json:"current_page,omitzero"`
+	PageSize     int    `json:"page_size,omitzero"`
+	FirstPage    int    `json:"first_page,omitzero"`
+	LastPage     int    `json:"last_page,omitzero"`
+	TotalRecords int    `json:"total_record,omitzero"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetaData(totalRecords, page, PageSize int) Metadata {
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// a canonical genre, movies refer to it by slug
+type Genre struct {
+	ID         int64    `json:"id"`
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases,omitempty"` // other spellings normalised to the slug eg sci-fi
+	Parent     string   `json:"parent,omitempty"`  // slug of the broader genre eg comedy for romantic-comedy
+	MovieCount int      `json:"movie_count"`       // read only, movies outside the trash
+}
+
+var genreSlugRX = regexp.MustCompile("[^a-z0-9]+")
+
+// mirrors the slug expression of the genres migration
+func genreSlug(genre string) string {
+	return strings.Trim(genreSlugRX.ReplaceAllString(strings.ToLower(genre), "-"), "-")
+}
+
+// lower case with single spaces, the way aliases are stored
+func normaliseGenre(genre string) string {
+	return strings.ToLower(strings.Join(strings.Fields(genre), " "))
+}
+
+// maps whatever users call a genre to its canonical slug
+type GenreTaxonomy struct {
+	slugs map[string]string
+}
+
+func NewGenreTaxonomy(genres []*Genre) *GenreTaxonomy {
+	t := &GenreTaxonomy{slugs: make(map[string]string)}
+
+	for _, genre := range genres {
+		t.slugs[genre.Slug] = genre.Slug
+		for _, alias := range genre.Aliases {
+			// the first genre claiming an alias keeps it
+			if _, ok := t.slugs[alias]; !ok {
+				t.slugs[alias] = genre.Slug
+			}
+		}
+	}
+
+	return t
+}
+
+// reports false if the genre isn't known by any name
+func (t *GenreTaxonomy) Canonical(genre string) (string, bool) {
+	if slug, ok := t.slugs[normaliseGenre(genre)]; ok {
+		return slug, true
+	}
+
+	// eg Science Fiction -> science-fiction
+	slug := genreSlug(genre)
+	if _, ok := t.slugs[slug]; ok && t.slugs[slug] == slug {
+		return slug, true
+	}
+
+	return "", false
+}
+
+// replaces known genres with their slugs in place and returns the unknown ones
+func (t *GenreTaxonomy) Normalise(genres []string) []string {
+	var unknown []string
+
+	for i, genre := range genres {
+		slug, ok := t.Canonical(genre)
+		if !ok {
+			unknown = append(unknown, genre)
+			continue
+		}
+
+		genres[i] = slug
+	}
+
+	return unknown
+}
+
+// the taxonomy only changes through migrations so a short lived copy saves a query on every write
+const genreTaxonomyTTL = time.Minute
+
+type genreCache struct {
+	mu       sync.Mutex
+	taxonomy *GenreTaxonomy
+	loadedAt time.Time
+}
+
+type GenreModel struct {
+	DB    *sql.DB
+	cache *genreCache
+}
+
+// every genre ordered by name along with the number of movies in it
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT genres.id, genres.slug, genres.name, genres.aliases, COALESCE(parent.slug, ''),
+			(SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+		FROM genres
+		LEFT JOIN genres AS parent ON parent.id = genres.parent_id
+		ORDER BY genres.name, genres.id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.Slug,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.Parent,
+			&genre.MovieCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// the taxonomy ValidateMovie checks genres against, reloaded at most once a minute
+func (m GenreModel) Taxonomy() (*GenreTaxonomy, error) {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	if m.cache.taxonomy != nil && time.Since(m.cache.loadedAt) < genreTaxonomyTTL {
+		return m.cache.taxonomy, nil
+	}
+
+	query := `
+		SELECT slug, aliases
+		FROM genres
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Slug, pq.Array(&genre.Aliases))
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	m.cache.taxonomy = NewGenreTaxonomy(genres)
+	m.cache.loadedAt = time.Now()
+
+	return m.cache.taxonomy, nil
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// response stored against an Idempotency-Key so that retries get the same answer
+// StatusCode is 0 while the original request is still in flight
+type IdempotencyRecord struct {
+	ReservedAt  time.Time // identifies the reservation so a request whose lease was taken over can't clobber the new one
+	Scope       string
+	Key         string
+	Fingerprint []byte
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	Expiry      time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// claims the key for a new request
+// returns nil when the key was free, otherwise the record of the request that already holds it
+// an expired record is taken over as if the key were free, and so is one still in flight after the lease
+// the lease only has to outlive a request, the stored response is kept until the expiry
+func (m IdempotencyModel) Reserve(record *IdempotencyRecord, lease time.Duration) (*IdempotencyRecord, error) {
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, expiry)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, headers = NULL, body = NULL,
+			created_at = NOW(), expiry = EXCLUDED.expiry
+		WHERE idempotency_keys.expiry <= NOW()
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at + make_interval(secs => $5) <= NOW())
+		RETURNING created_at
+	`
+
+	args := []any{record.Scope, record.Key, record.Fingerprint, record.Expiry, lease.Seconds()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// no row comes back when the key is held by someone else
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&record.ReservedAt)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	query = `
+		SELECT scope, key, fingerprint, COALESCE(status_code, 0), headers, body, expiry
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`
+
+	var (
+		existing IdempotencyRecord
+		header   []byte
+	)
+
+	err = m.DB.QueryRowContext(ctx, query, record.Scope, record.Key).Scan(
+		&existing.Scope,
+		&existing.Key,
+		&existing.Fingerprint,
+		&existing.StatusCode,
+		&header,
+		&existing.Body,
+		&existing.Expiry,
+	)
+	if err != nil {
+		switch {
+		// released between the INSERT and the SELECT, the client can simply retry
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	if header != nil {
+		err = json.Unmarshal(header, &existing.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &existing, nil
+}
+
+// stores the response of the request holding the key
+// does nothing if the reservation was taken over in the meantime
+func (m IdempotencyModel) Complete(record *IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, headers = $2, body = $3
+		WHERE scope = $4 AND key = $5 AND created_at = $6
+	`
+
+	args := []any{record.StatusCode, header, record.Body, record.Scope, record.Key, record.ReservedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// frees the key so that the request can be retried
+// a reservation taken over in the meantime is left alone
+func (m IdempotencyModel) Release(record *IdempotencyRecord) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE scope = $1 AND key = $2 AND created_at = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, record.Scope, record.Key, record.ReservedAt)
+	return err
+}
+
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expiry <= $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// consts for the stages of an import job
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// problems with a single row of an import
+// line is the line of the upload the row came from
+type ImportRowError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// outcome of an import, invalid rows are skipped and reported
+type ImportReport struct {
+	TotalRows    int              `json:"total_rows"`
+	ValidRows    int              `json:"valid_rows"`
+	ImportedRows int              `json:"imported_rows"`
+	Errors       []ImportRowError `json:"errors"`
+}
+
+// import too large to run within a request
+type ImportJob struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	Format string `json:"format"`
+	ImportReport
+	CreatedBy  *int       `json:"created_by"` // nil once the user who started the import is deleted
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitzero"`
+}
+
+type ImportJobModel struct {
+	DB *sql.DB
+}
+
+func (m ImportJobModel) Insert(job *ImportJob, userID int) error {
+	errs, err := json.Marshal(job.Errors)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO import_jobs (status, format, total_rows, valid_rows, errors, created_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+		RETURNING id, created_by, created_at
+	`
+
+	args := []any{job.Status, job.Format, job.TotalRows, job.ValidRows, errs, userID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.CreatedBy, &job.CreatedAt)
+}
+
+func (m ImportJobModel) Get(id int) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, status, format, total_rows, valid_rows, imported_rows, errors, created_by, created_at, finished_at
+		FROM import_jobs
+		WHERE id = $1
+	`
+
+	var (
+		job  ImportJob
+		errs []byte
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.Status,
+		&job.Format,
+		&job.TotalRows,
+		&job.ValidRows,
+		&job.ImportedRows,
+		&errs,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(errs, &job.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// records the job's progress, finished_at is set once it completes or fails
+func (m ImportJobModel) UpdateStatus(job *ImportJob) error {
+	query := `
+		UPDATE import_jobs
+		SET status = $1, imported_rows = $2,
+			finished_at = CASE WHEN $1 IN ('completed', 'failed') THEN NOW() END
+		WHERE id = $3
+		RETURNING finished_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, job.Status, job.ImportedRows, job.ID).Scan(&job.FinishedAt)
+}
+
+// jobs run on a goroutine of the process that accepted the upload so a restart or crash leaves them
+// pending or running forever, any job older than olderThan can't still be going and is marked as failed
+func (m ImportJobModel) FailStale(olderThan time.Duration) (int64, error) {
+	query := `
+		UPDATE import_jobs
+		SET status = 'failed', finished_at = NOW()
+		WHERE status IN ('pending', 'running')
+		AND created_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// lets an admin onboard someone with a pre-assigned set of permissions
+// the invitee proves they own the email by presenting the plaintext token
+type Invitation struct {
+	ID          int         `json:"id"`
+	PlainText   string      `json:"-"`
+	Hash        []byte      `json:"-"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+	InvitedBy   int         `json:"invited_by"`
+	Expiry      time.Time   `json:"expiry"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+func (m InvitationModel) New(email string, permissions Permissions, invitedBy int, ttl time.Duration) (*Invitation, error) {
+	invitation := &Invitation{
+		PlainText:   rand.Text(),
+		Email:       email,
+		Permissions: permissions,
+		InvitedBy:   invitedBy,
+		Expiry:      time.Now().Add(ttl),
+	}
+
+	hash := sha256.Sum256([]byte(invitation.PlainText))
+	invitation.Hash = hash[:]
+
+	err := m.Insert(invitation)
+	return invitation, err
+}
+
+func (m InvitationModel) Insert(invitation *Invitation) error {
+	query := `
+		INSERT INTO invitations (hash, email, permissions, invited_by, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	args := []any{
+		invitation.Hash,
+		invitation.Email,
+		pq.Array(invitation.Permissions),
+		invitation.InvitedBy,
+		invitation.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+// only returns invitations that are still pending
+func (m InvitationModel) GetByToken(tokenPlainText string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+		SELECT id, hash, email, permissions, COALESCE(invited_by, 0), expiry, created_at
+		FROM invitations
+		WHERE hash = $1
+		AND accepted_at IS NULL
+		AND expiry > $2
+	`
+
+	var (
+		invitation Invitation
+		codes      []string
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.Hash,
+		&invitation.Email,
+		pq.Array(&codes),
+		&invitation.InvitedBy,
+		&invitation.Expiry,
+		&invitation.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	for _, code := range codes {
+		invitation.Permissions = append(invitation.Permissions, Permission(code))
+	}
+
+	return &invitation, nil
+}
+
+// creates the invited user with the invitation's permissions and uses up the invitation, all or nothing
+// the invitation row stays locked until commit so concurrent accepts of the same token can't both succeed
+// returns ErrRecordNotFound if the invitation has been accepted or has expired in the meantime
+func (m InvitationModel) Accept(invitation *Invitation, user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE invitations
+		SET accepted_at = NOW()
+		WHERE id = $1
+		AND accepted_at IS NULL
+		AND expiry > NOW()
+	`
+
+	result, err := tx.ExecContext(ctx, query, invitation.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = addUserPermissions(ctx, tx, user.ID, invitation.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -11,17 +11,43 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Tokens      TokenModel
-	Users       UserModel
-	Permissions PermissionsModel
+	Movies            MovieModel
+	Tokens            TokenModel
+	Users             UserModel
+	Permissions       PermissionsModel
+	Exports           ExportModel
+	Invitations       InvitationModel
+	Revisions         MovieRevisionModel
+	ImportJobs        ImportJobModel
+	Idempotency       IdempotencyModel
+	People            PersonModel
+	Credits           CreditModel
+	Reviews           ReviewModel
+	Watchlist         WatchlistModel
+	Watched           WatchedModel
+	Collections       CollectionModel
+	CollectionEntries CollectionEntryModel
+	Genres            GenreModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
-		Permissions: PermissionsModel{DB: db},
+		Movies:            MovieModel{DB: db},
+		Tokens:            TokenModel{DB: db},
+		Users:             UserModel{DB: db},
+		Permissions:       PermissionsModel{DB: db},
+		Exports:           ExportModel{DB: db},
+		Invitations:       InvitationModel{DB: db},
+		Revisions:         MovieRevisionModel{DB: db},
+		ImportJobs:        ImportJobModel{DB: db},
+		Idempotency:       IdempotencyModel{DB: db},
+		People:            PersonModel{DB: db},
+		Credits:           CreditModel{DB: db},
+		Reviews:           ReviewModel{DB: db},
+		Watchlist:         WatchlistModel{DB: db},
+		Watched:           WatchedModel{DB: db},
+		Collections:       CollectionModel{DB: db},
+		CollectionEntries: CollectionEntryModel{DB: db},
+		Genres:            GenreModel{DB: db, cache: &genreCache{}},
 	}
 }
@@ -1,11 +1,15 @@
 package data
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"greenlight/internal/validator"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -18,14 +22,51 @@ type Movie struct {
 	Title string `json:"title"`
 	// here we cap int to int32 so that it matches with postgres Integer type and avoid introducing errors when we exceed int32's upper and lower limits
 	// or integer overflow errors see >>> https://go.dev/ref/spec#Integer_overflow
-	Year      int32     `json:"year,omitzero"`    // omitzero introduced in go 1.24 removes the field if it has the zero value of the type
-	Runtime   Runtime   `json:"runtime,omitzero"` // Movie runtime in minutes
-	Genres    []string  `json:"genres,omitempty"` // Slice of genres for the movie (romance, comedy, etc.) omitempty useful for slices & maps
-	Version   int32     `json:"version"`          // Version starts at one and will be incremented each time movie information is updated
-	CreatedAt time.Time `json:"-"`                // -  omits the field entirely from json response
+	Year      int32      `json:"year,omitzero"`       // omitzero introduced in go 1.24 removes the field if it has the zero value of the type
+	Runtime   Runtime    `json:"runtime,omitzero"`    // Movie runtime in minutes
+	Genres    []string   `json:"genres,omitempty"`    // Slice of genres for the movie (romance, comedy, etc.) omitempty useful for slices & maps
+	Version   int32      `json:"version"`             // Version starts at one and will be incremented each time movie information is updated
+	CreatedAt time.Time  `json:"-"`                   // -  omits the field entirely from json response
+	UpdatedAt time.Time  `json:"-"`                   // served as the Last-Modified header instead
+	DeletedAt *time.Time `json:"deleted_at,omitzero"` // only set for movies in the trash
+	DeletedBy *int       `json:"deleted_by,omitzero"` // likewise, nil if the user who deleted it no longer exists
+	// ids of the movie in other catalogues
+	ExternalIDs ExternalIDs `json:"external_ids,omitzero"`
+	// read only aggregates over the movie's visible reviews, zero until it's reviewed
+	RatingAverage float64 `json:"rating_average,omitzero"`
+	RatingCount   int32   `json:"rating_count,omitzero"`
 }
 
-func ValidateMovie(v *validator.Validator, m *Movie) map[string]string {
+// columns selected whenever a full movie is read
+// keep in sync with scanMovie
+const movieColumns = "id, title, year, runtime, genres, version, created_at, updated_at, deleted_at, deleted_by, " +
+	"COALESCE(imdb_id, ''), COALESCE(tmdb_id, ''), COALESCE(wikidata_id, ''), rating_average, rating_count"
+
+// prefix holds destinations for any columns selected before movieColumns eg count(*) OVER()
+func scanMovie(row interface{ Scan(...any) error }, movie *Movie, prefix ...any) error {
+	dest := append(prefix,
+		&movie.ID,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.DeletedAt,
+		&movie.DeletedBy,
+		&movie.ExternalIDs.IMDb,
+		&movie.ExternalIDs.TMDb,
+		&movie.ExternalIDs.Wikidata,
+		&movie.RatingAverage,
+		&movie.RatingCount,
+	)
+
+	return row.Scan(dest...)
+}
+
+// known genres are replaced with their canonical slugs so that eg sci-fi and Science Fiction are stored alike
+func ValidateMovie(v *validator.Validator, m *Movie, genres *GenreTaxonomy) map[string]string {
 	v.Check(m.Title != "", "title", "must be provided")
 	v.Check(len(m.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -39,8 +80,13 @@ func ValidateMovie(v *validator.Validator, m *Movie) map[string]string {
 	v.Check(m.Genres != nil, "genres", "must be provided")
 	v.Check(len(m.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(m.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	if unknown := genres.Normalise(m.Genres); len(unknown) > 0 {
+		v.AddError("genres", fmt.Sprintf("must only contain known genres, unknown: %s", strings.Join(unknown, ", ")))
+	}
 	v.Check(validator.Unique(m.Genres), "genres", "must not contain duplicate values")
 
+	validateExternalIDs(v, externalIDRX, m.ExternalIDs)
+
 	if !v.Valid() {
 		return v.Errors
 	}
@@ -52,50 +98,282 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// movie changes made within a single transaction
+// every change is recorded as a revision authored by userID
+type MovieTx struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	userID int
+}
+
+// runs fn in a transaction that's committed when fn returns nil and rolled back otherwise
+func (m MovieModel) transaction(timeout time.Duration, userID int, fn func(t *MovieTx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Rollback is a no-op once the transaction has been committed
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(&MovieTx{ctx: ctx, tx: tx, userID: userID})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applies several changes at once, they're either all committed or none are
+// also used when what to write depends on what's read eg upserts
+func (m MovieModel) Batch(userID int, fn func(t *MovieTx) error) error {
+	return m.transaction(10*time.Second, userID, fn)
+}
+
+// userID is recorded as the author of the revision
+func (m MovieModel) Insert(movie *Movie, userID int) error {
+	return m.transaction(3*time.Second, userID, func(t *MovieTx) error {
+		return t.Insert(movie)
+	})
+}
+
+func (t *MovieTx) Insert(movie *Movie) error {
+	// missing external ids are stored as NULL so that they don't clash with each other
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version
+		INSERT INTO movies (title, year, runtime, genres, imdb_id, tmdb_id, wikidata_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))
+		RETURNING id, created_at, updated_at, version
 	`
 	// slice containing the values for the placeholder parameters
 	// it's good practice to put args in a slice if we are passing more than 3 args
 	// pq.Array converts []string to pq.StringArray
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []any{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.ExternalIDs.IMDb,
+		movie.ExternalIDs.TMDb,
+		movie.ExternalIDs.Wikidata,
+	}
 
 	// .Scan copies values of ID, createdAt and Version from the DB
 	// .Scan can only write to a pointer type
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := t.tx.QueryRowContext(t.ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return externalIDError("movies", err)
+	}
+
+	return insertRevision(t.ctx, t.tx, movie, RevisionActionCreate, diffMovies(nil, movie), t.userID)
+}
+
+// movies inserted per statement when inserting in bulk
+// keeps the number of placeholders well below the postgres limit of 65535
+const insertBatchSize = 500
+
+// inserts every movie along with its create revision in a single transaction
+// either all of them are inserted or none are
+func (m MovieModel) InsertMany(movies []*Movie, userID int) error {
+	// imports can be large so they get more time than a single insert
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for batch := range slices.Chunk(movies, insertBatchSize) {
+		err = insertMovies(ctx, tx, batch)
+		if err != nil {
+			return err
+		}
+
+		err = insertCreateRevisions(ctx, tx, batch, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// multi row insert for a batch of movies
+// ids are handed out in the order the rows are inserted, which the ORDER BY fixes to the order
+// of the batch, so sorting the returned rows by id lines them up with the movies
+func insertMovies(ctx context.Context, tx *sql.Tx, movies []*Movie) error {
+	values := make([]string, len(movies))
+	args := make([]any, 0, len(movies)*8)
+
+	for i, movie := range movies {
+		n := len(args)
+		values[i] = fmt.Sprintf("($%d::integer, $%d::text, $%d::integer, $%d::integer, $%d::text[], $%d::text, $%d::text, $%d::text)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8)
+		args = append(args, i, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres),
+			movie.ExternalIDs.IMDb, movie.ExternalIDs.TMDb, movie.ExternalIDs.Wikidata)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO movies (title, year, runtime, genres, imdb_id, tmdb_id, wikidata_id)
+		SELECT title, year, runtime, genres, NULLIF(imdb_id, ''), NULLIF(tmdb_id, ''), NULLIF(wikidata_id, '')
+		FROM (VALUES %s) AS batch (position, title, year, runtime, genres, imdb_id, tmdb_id, wikidata_id)
+		ORDER BY position
+		RETURNING id, created_at, updated_at, version
+	`, strings.Join(values, ", "))
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return externalIDError("movies", err)
+	}
+	defer rows.Close()
+
+	inserted := make([]Movie, 0, len(movies))
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+		inserted = append(inserted, movie)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(inserted) != len(movies) {
+		return fmt.Errorf("inserted %d of %d movies", len(inserted), len(movies))
+	}
+
+	slices.SortFunc(inserted, func(a, b Movie) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	for i, movie := range movies {
+		movie.ID = inserted[i].ID
+		movie.CreatedAt = inserted[i].CreatedAt
+		movie.UpdatedAt = inserted[i].UpdatedAt
+		movie.Version = inserted[i].Version
+	}
+
+	return nil
+}
+
+// resolves genres to slugs in the database the same way GenreTaxonomy.Canonical does
+// so that filtering doesn't need the taxonomy
+// unknown genres are kept as they are and therefore match no movie
+func canonicalGenres(w *where, genres []string) string {
+	normalised := make([]string, len(genres))
+	slugs := make([]string, len(genres))
+	for i, genre := range genres {
+		normalised[i] = normaliseGenre(genre)
+		slugs[i] = genreSlug(genre)
+	}
+
+	return "ARRAY(SELECT COALESCE(" +
+		"(SELECT slug FROM genres WHERE slug = given OR given = ANY(aliases) ORDER BY slug <> given, id LIMIT 1), " +
+		"(SELECT slug FROM genres WHERE slug = slugged), given) " +
+		"FROM unnest(" + w.arg(pq.Array(normalised)) + "::text[], " + w.arg(pq.Array(slugs)) + "::text[]) AS given_genres (given, slugged))"
 }
 
+// compiles the filter into w
 // fulltext search does not support searching parts of a word eg bookshelf -> book
-// to search parts of a word consider using `pg_trgm` or `ILIKE`
-// `ILIKE` performs full table scans therefore not ideal
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Search uses `pg_trgm` for that, `ILIKE` performs full table scans therefore not ideal
+func (f MovieFilter) where(w *where) {
+	if f.Title != "" {
+		// to_tsvector:- splits title into lexemes and removes commonly occuring words
+		// simple:- converts title to lower case versions
+		// @@ matches operator
+		w.add("to_tsvector('simple', title) @@ plainto_tsquery('simple', " + w.arg(f.Title) + ")")
+	}
+
+	if len(f.Genres) > 0 {
+		switch f.GenresMode {
+		case GenresModeAny:
+			// && overlap operator for PostgreSQL arrays
+			w.add("genres && " + canonicalGenres(w, f.Genres))
+		case GenresModeNone:
+			w.add("NOT (genres && " + canonicalGenres(w, f.Genres) + ")")
+		default:
+			// @> contains operator for PostrgreSQL arrays
+			w.add("genres @> " + canonicalGenres(w, f.Genres))
+		}
+	}
+
+	if len(f.ExcludeGenres) > 0 {
+		w.add("NOT (genres && " + canonicalGenres(w, f.ExcludeGenres) + ")")
+	}
+
+	if f.YearMin != 0 {
+		w.add("year >= " + w.arg(f.YearMin))
+	}
+	if f.YearMax != 0 {
+		w.add("year <= " + w.arg(f.YearMax))
+	}
+	if f.RuntimeMin != 0 {
+		w.add("runtime >= " + w.arg(f.RuntimeMin))
+	}
+	if f.RuntimeMax != 0 {
+		w.add("runtime <= " + w.arg(f.RuntimeMax))
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		w.add("created_at >= " + w.arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		w.add("created_at < " + w.arg(f.CreatedBefore))
+	}
+
+	if f.PersonID != 0 {
+		credited := "SELECT movie_id FROM credits WHERE person_id = " + w.arg(f.PersonID)
+		if f.PersonRole != "" {
+			credited += " AND role = " + w.arg(f.PersonRole)
+		}
+		w.add("id IN (" + credited + ")")
+	}
+
+	if f.WatchlistUser != 0 {
+		watchlisted := "id IN (SELECT movie_id FROM watchlist_items WHERE user_id = " + w.arg(f.WatchlistUser) + ")"
+		if !f.Watchlisted {
+			watchlisted = "NOT " + watchlisted
+		}
+		w.add(watchlisted)
+	}
+
+	// trashed movies are only visible through GetAllDeleted
+	w.add("deleted_at IS NULL")
+}
+
+func (m MovieModel) GetAll(movieFilter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	if filters.UseCursor {
+		return m.getAllByCursor(movieFilter, filters)
+	}
+
+	var w where
+	movieFilter.where(&w)
+
+	// count(*) OVER() has to visit every matching row
+	total := "count(*) OVER()"
+	if filters.SkipTotal {
+		total = "0"
+	}
+
 	// column names and sql keywords cannot be inserted into a query
 	// using placeholder parameters `$x` hence the use of Sprintf
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, title, year, runtime, genres, version, created_at
+		SELECT %s, %s
 		FROM movies
-		-- deprecated WHERE (LOWER(title) = LOWER($1) OR $1 = '')
-		-- to_tsvector:- splits title into lexemes and removes commonly occuring words
-		-- simple:- converts title to lower case versions
-		-- @@ matches operator
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		-- @> contains operator for PostrgreSQL arrays
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
-	`, filters.sortColumn(), filters.sortDirection())
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, total, movieColumns, w.String(), filters.orderBy(false), w.arg(filters.limit()), w.arg(filters.offset()))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -107,16 +385,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	movies := []*Movie{}
 	for rows.Next() {
 		var movie Movie
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-			&movie.CreatedAt,
-		)
+		err := scanMovie(rows, &movie, &totalRecords)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -127,21 +396,187 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
+	if filters.SkipTotal {
+		return movies, Metadata{CurrentPage: filters.Page, PageSize: filters.PageSize, FirstPage: 1}, nil
+	}
+
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 
 	return movies, metadata, nil
 }
 
+// keyset pagination seeks straight to the cursor using the sort column
+// instead of reading and discarding OFFSET rows, so deep pages are as fast as the first
+// and rows inserted or deleted between requests don't shift the pages
+func (m MovieModel) getAllByCursor(movieFilter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	cursor := filters.cursor()
+	backward := cursor != nil && cursor.Backward
+
+	var w where
+	movieFilter.where(&w)
+
+	// the total ignores the cursor
+	countQuery := "SELECT count(*) FROM movies WHERE " + w.String()
+	countArgs := slices.Clone(w.args)
+
+	if cursor != nil {
+		w.add(filters.keysetCondition(cursor, &w))
+	}
+
+	// one extra row tells us whether there's another page
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movies
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s
+	`, movieColumns, w.String(), filters.orderBy(backward), w.arg(filters.limit()+1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	keys := filters.sortKeys()
+	movies, metadata := keysetPage(movies, filters, cursor, func(movie *Movie) Cursor {
+		return movie.position(keys)
+	})
+
+	if filters.SkipTotal {
+		return movies, metadata, nil
+	}
+
+	err = m.DB.QueryRowContext(ctx, countQuery, countArgs...).Scan(&metadata.TotalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, metadata, nil
+}
+
+// calls batch with every movie matching filter in sort order, batchSize movies at a time
+// the rows are read through a server-side cursor so the result set is never held in memory
+// ctx rather than a fixed timeout bounds the query since a full export can take a while
+func (m MovieModel) Stream(ctx context.Context, filter MovieFilter, filters Filters, batchSize int, batch func([]*Movie) error) error {
+	var w where
+	filter.where(&w)
+
+	query := fmt.Sprintf(`
+		DECLARE movies_stream NO SCROLL CURSOR FOR
+		SELECT %s
+		FROM movies
+		WHERE %s
+		ORDER BY %s
+	`, movieColumns, w.String(), filters.orderBy(false))
+
+	// cursors only live as long as the transaction they were declared in
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, w.args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH %d FROM movies_stream", batchSize)
+
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+
+		movies := make([]*Movie, 0, batchSize)
+		for rows.Next() {
+			var movie Movie
+			err := scanMovie(rows, &movie)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			movies = append(movies, &movie)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		// the cursor is exhausted
+		if len(movies) == 0 {
+			break
+		}
+
+		err = batch(movies)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// where the movie sits in a list sorted by keys
+func (movie *Movie) position(keys []sortKey) Cursor {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = movie.sortValue(key.column)
+	}
+
+	return Cursor{Values: values, ID: movie.ID}
+}
+
+// string form of the movie's value for a sort column
+// Postgres casts it back to the column type when it's compared
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	case "rating_average":
+		return strconv.FormatFloat(movie.RatingAverage, 'f', -1, 64)
+	case "rating_count":
+		return strconv.Itoa(int(movie.RatingCount))
+	}
+
+	panic("unsupported sort column: " + column)
+}
+
 func (m MovieModel) Get(id int) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		SELECT id, title, year, runtime, genres, version 
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM movies
 		WHERE id = $1
-	`
+		AND deleted_at IS NULL
+	`, movieColumns)
 
 	// nil struct to hold data returned by the query
 	var movie Movie
@@ -153,14 +588,7 @@ func (m MovieModel) Get(id int) (*Movie, error) {
 	// thereby preventing a memory leak
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := scanMovie(m.DB.QueryRowContext(ctx, query, id), &movie)
 
 	// If no matching movie was found Scan() will return sql.ErrNoRows
 	if err != nil {
@@ -174,12 +602,91 @@ func (m MovieModel) Get(id int) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// locks the movie until the transaction ends
+func (t *MovieTx) Get(id int) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movies
+		WHERE id = $1
+		AND deleted_at IS NULL
+		FOR UPDATE
+	`, movieColumns)
+
+	var movie Movie
+
+	err := scanMovie(t.tx.QueryRowContext(t.ctx, query, id), &movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+func (m MovieModel) Update(movie *Movie, userID int) error {
+	return m.transaction(3*time.Second, userID, func(t *MovieTx) error {
+		return t.Update(movie)
+	})
+}
+
+// restores the contents of an older revision
+// movie must carry the current version so it goes through the same optimistic locking as Update
+func (m MovieModel) Rollback(movie *Movie, userID int) error {
+	return m.transaction(3*time.Second, userID, func(t *MovieTx) error {
+		return t.update(movie, RevisionActionRollback)
+	})
+}
+
+func (t *MovieTx) Update(movie *Movie) error {
+	return t.update(movie, RevisionActionUpdate)
+}
+
+func (t *MovieTx) update(movie *Movie, action string) error {
+	// lock the row and grab its current state for the revision diff
+	// no rows means the version has changed or the movie has been deleted
 	query := `
+		SELECT title, year, runtime, genres, COALESCE(imdb_id, ''), COALESCE(tmdb_id, ''), COALESCE(wikidata_id, '')
+		FROM movies
+		WHERE id = $1 AND version = $2
+		AND deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	var before Movie
+
+	err := t.tx.QueryRowContext(t.ctx, query, movie.ID, movie.Version).Scan(
+		&before.Title,
+		&before.Year,
+		&before.Runtime,
+		pq.Array(&before.Genres),
+		&before.ExternalIDs.IMDb,
+		&before.ExternalIDs.TMDb,
+		&before.ExternalIDs.Wikidata,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version
+		SET title = $1, year = $2, runtime = $3, genres = $4,
+			imdb_id = NULLIF($5, ''), tmdb_id = NULLIF($6, ''), wikidata_id = NULLIF($7, ''),
+			updated_at = NOW(), version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING updated_at, version
 	`
 
 	args := []any{
@@ -187,17 +694,53 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.ExternalIDs.IMDb,
+		movie.ExternalIDs.TMDb,
+		movie.ExternalIDs.Wikidata,
 		movie.ID,
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// Prevent race condition through Optimistic locking
 	// If no matching record could be found, we know movie
 	// version has (changed or the record has been deleted) and we return a custom error
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = t.tx.QueryRowContext(t.ctx, query, args...).Scan(&movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return externalIDError("movies", err)
+		}
+	}
+
+	return insertRevision(t.ctx, t.tx, movie, action, diffMovies(&before, movie), t.userID)
+}
+
+// moves the movie to the trash
+// it's only removed for good by PurgeDeleted once the retention period has elapsed
+// movie must carry the version the caller expects to delete, it's updated in place
+func (m MovieModel) Delete(movie *Movie, userID int) error {
+	return m.transaction(3*time.Second, userID, func(t *MovieTx) error {
+		return t.Delete(movie)
+	})
+}
+
+func (t *MovieTx) Delete(movie *Movie) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE movies
+		SET deleted_at = NOW(), deleted_by = NULLIF($3, 0), updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2
+		AND deleted_at IS NULL
+		RETURNING %s
+	`, movieColumns)
+
+	// no rows means the version has changed or the movie is already in the trash
+	err := scanMovie(t.tx.QueryRowContext(t.ctx, query, movie.ID, movie.Version, t.userID), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -207,36 +750,111 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	diff := map[string]FieldChange{"deleted": {From: false, To: true}}
+
+	return insertRevision(t.ctx, t.tx, movie, RevisionActionDelete, diff, t.userID)
 }
 
-func (m MovieModel) Delete(id int) error {
+// takes a movie back out of the trash
+func (m MovieModel) Restore(id int, userID int) (*Movie, error) {
 	if id < 1 {
-		return ErrRecordNotFound
+		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		DELETE FROM movies
+	query := fmt.Sprintf(`
+		UPDATE movies
+		SET deleted_at = NULL, deleted_by = NULL, updated_at = NOW(), version = version + 1
 		WHERE id = $1
-	`
+		AND deleted_at IS NOT NULL
+		RETURNING %s
+	`, movieColumns)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
+
+	var movie Movie
 
-	rowsAffected, err := result.RowsAffected()
+	err = scanMovie(tx.QueryRowContext(ctx, query, id), &movie)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	// if rows affected is zero
-	// it means no movie with given id exists
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	diff := map[string]FieldChange{"deleted": {From: true, To: false}}
+
+	err = insertRevision(ctx, tx, &movie, RevisionActionRestore, diff, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &movie, tx.Commit()
+}
+
+// lists the movies in the trash
+func (m MovieModel) GetAllDeleted(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s
+		LIMIT $1 OFFSET $2
+	`, movieColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// permanently deletes movies that have been in the trash for longer than retention
+// their revisions are kept
+func (m MovieModel) PurgeDeleted(retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
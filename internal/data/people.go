@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+)
+
+var ErrPersonCredited = errors.New("person is credited")
+
+// anyone who worked on a movie, their roles are recorded as credits
+type Person struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	BirthYear   int32       `json:"birth_year,omitzero"`   // unknown when zero
+	ExternalIDs ExternalIDs `json:"external_ids,omitzero"` // ids of the person in other catalogues
+	Version     int32       `json:"version"`
+	CreatedAt   time.Time   `json:"-"`
+}
+
+// keep in sync with scanPerson
+const personColumns = "id, name, COALESCE(birth_year, 0), " +
+	"COALESCE(imdb_id, ''), COALESCE(tmdb_id, ''), COALESCE(wikidata_id, ''), version, created_at"
+
+// prefix holds destinations for any columns selected before personColumns eg count(*) OVER()
+func scanPerson(row interface{ Scan(...any) error }, person *Person, prefix ...any) error {
+	dest := append(prefix,
+		&person.ID,
+		&person.Name,
+		&person.BirthYear,
+		&person.ExternalIDs.IMDb,
+		&person.ExternalIDs.TMDb,
+		&person.ExternalIDs.Wikidata,
+		&person.Version,
+		&person.CreatedAt,
+	)
+
+	return row.Scan(dest...)
+}
+
+func ValidatePerson(v *validator.Validator, p *Person) {
+	v.Check(p.Name != "", "name", "must be provided")
+	v.Check(len(p.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if p.BirthYear != 0 {
+		v.Check(p.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(p.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+
+	validateExternalIDs(v, personExternalIDRX, p.ExternalIDs)
+}
+
+type PersonModel struct {
+	DB *sql.DB
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	// unknown values are stored as NULL, external ids so that they don't clash with each other
+	query := `
+		INSERT INTO people (name, birth_year, imdb_id, tmdb_id, wikidata_id)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''))
+		RETURNING id, version, created_at
+	`
+
+	args := []any{
+		person.Name,
+		person.BirthYear,
+		person.ExternalIDs.IMDb,
+		person.ExternalIDs.TMDb,
+		person.ExternalIDs.Wikidata,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.Version, &person.CreatedAt)
+	if err != nil {
+		return externalIDError("people", err)
+	}
+
+	return nil
+}
+
+func (m PersonModel) Get(id int) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM people
+		WHERE id = $1
+	`, personColumns)
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanPerson(m.DB.QueryRowContext(ctx, query, id), &person)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// name matches whole words the same way the movie title filter does
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	var w where
+	if name != "" {
+		w.add("to_tsvector('simple', name) @@ plainto_tsquery('simple', " + w.arg(name) + ")")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM people
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, personColumns, w.String(), filters.orderBy(false), w.arg(filters.limit()), w.arg(filters.offset()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+
+		err := scanPerson(rows, &person, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+func (m PersonModel) Update(person *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = NULLIF($2, 0),
+			imdb_id = NULLIF($3, ''), tmdb_id = NULLIF($4, ''), wikidata_id = NULLIF($5, ''),
+			version = version + 1
+		WHERE id = $6 AND version = $7
+		RETURNING version
+	`
+
+	args := []any{
+		person.Name,
+		person.BirthYear,
+		person.ExternalIDs.IMDb,
+		person.ExternalIDs.TMDb,
+		person.ExternalIDs.Wikidata,
+		person.ID,
+		person.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return externalIDError("people", err)
+		}
+	}
+
+	return nil
+}
+
+// people who are still credited on a movie can't be deleted, their credits have to go first
+func (m PersonModel) Delete(id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM people
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: update or delete on table "people" violates foreign key constraint "credits_person_id_fkey" on table "credits"`:
+			return ErrPersonCredited
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"greenlight/internal/validator"
 	"slices"
 	"time"
 
@@ -16,12 +18,47 @@ type Permissions []Permission
 const (
 	PermissionMoviesRead  Permission = "movies:read"
 	PermissionMoviesWrite Permission = "movies:write"
+	PermissionMoviesAdmin Permission = "movies:admin"
+	PermissionUsersAdmin  Permission = "users:admin"
+
+	PermissionUsersImpersonate Permission = "users:impersonate"
+	PermissionReviewsModerate  Permission = "reviews:moderate"
 )
 
+// every permission code seeded by the migrations
+var knownPermissions = Permissions{
+	PermissionMoviesRead,
+	PermissionMoviesWrite,
+	PermissionMoviesAdmin,
+	PermissionUsersAdmin,
+	PermissionUsersImpersonate,
+	PermissionReviewsModerate,
+}
+
+func ValidatePermissions(v *validator.Validator, permissions Permissions) {
+	v.Check(len(permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(permissions), "permissions", "must not contain duplicate values")
+
+	for _, permission := range permissions {
+		v.Check(knownPermissions.Includes(permission), "permissions", fmt.Sprintf("unknown permission %q", permission))
+	}
+}
+
 func (p Permissions) Includes(code Permission) bool {
 	return slices.Contains(p, code)
 }
 
+// reports whether every permission in p is also in other
+func (p Permissions) SubsetOf(other Permissions) bool {
+	for _, code := range p {
+		if !other.Includes(code) {
+			return false
+		}
+	}
+
+	return true
+}
+
 type PermissionsModel struct {
 	DB *sql.DB
 }
@@ -65,14 +102,19 @@ func (m PermissionsModel) GetUserPermissions(userID int) (Permissions, error) {
 
 // note ... variadic parameter for codes so that we can assign multiple permissions in a single call
 func (m PermissionsModel) AddUserPermissions(userID int, permissions ...Permission) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addUserPermissions(ctx, m.DB, userID, permissions)
+}
+
+// db is either the connection pool or a transaction the permissions are granted in
+func addUserPermissions(ctx context.Context, db querier, userID int, permissions []Permission) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(permissions))
+	_, err := db.ExecContext(ctx, query, userID, pq.Array(permissions))
 	return err
 }
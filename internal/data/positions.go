@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// movies kept in an order chosen by users eg a watchlist or a collection
+// positions start at 1 and may have gaps once movies are purged, callers lock the list beforehand
+type orderedMovies struct {
+	table string // eg watchlist_items
+	owner string // column holding the id of the list eg user_id
+}
+
+// frees up position for a movie that's about to be inserted by moving the movies from there on down one place
+// the position is clamped to the list, 0 means the end of it, the position to insert at is returned
+func (o orderedMovies) makeRoom(ctx context.Context, tx *sql.Tx, ownerID int64, position int32) (int32, error) {
+	// the end of the list is the highest position since there may be gaps
+	var last int32
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(max(position), 0) FROM %s WHERE %s = $1", o.table, o.owner), ownerID).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+
+	if position < 1 || position > last+1 {
+		return last + 1, nil
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE %s
+		SET position = position + 1
+		WHERE %s = $1
+		AND position >= $2
+	`, o.table, o.owner)
+
+	_, err = tx.ExecContext(ctx, query, ownerID, position)
+	return position, err
+}
+
+// moves the movie to position, the movies in between shift by one place to make room
+// the position is clamped to the list, the position the movie ended up at is returned
+func (o orderedMovies) move(ctx context.Context, tx *sql.Tx, ownerID, movieID int64, position int32) (int32, error) {
+	query := fmt.Sprintf(`
+		SELECT position, (SELECT max(position) FROM %[1]s WHERE %[2]s = $1)
+		FROM %[1]s
+		WHERE %[2]s = $1
+		AND movie_id = $2
+	`, o.table, o.owner)
+
+	var current, last int32
+
+	err := tx.QueryRowContext(ctx, query, ownerID, movieID).Scan(&current, &last)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	position = max(1, min(position, last))
+
+	// moving up pushes the movies in between down and vice versa
+	query = fmt.Sprintf(`
+		UPDATE %s
+		SET position = CASE
+			WHEN movie_id = $2 THEN $4::integer
+			WHEN $4::integer < $3::integer THEN position + 1
+			ELSE position - 1
+		END
+		WHERE %s = $1
+		AND position BETWEEN LEAST($3::integer, $4::integer) AND GREATEST($3::integer, $4::integer)
+	`, o.table, o.owner)
+
+	_, err = tx.ExecContext(ctx, query, ownerID, movieID, current, position)
+	return position, err
+}
+
+// removes the movie, the movies below it move up one place
+func (o orderedMovies) remove(ctx context.Context, tx *sql.Tx, ownerID, movieID int64) error {
+	query := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE %s = $1
+		AND movie_id = $2
+		RETURNING position
+	`, o.table, o.owner)
+
+	var position int32
+
+	err := tx.QueryRowContext(ctx, query, ownerID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = fmt.Sprintf(`
+		UPDATE %s
+		SET position = position - 1
+		WHERE %s = $1
+		AND position > $2
+	`, o.table, o.owner)
+
+	_, err = tx.ExecContext(ctx, query, ownerID, position)
+	return err
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// satisfied by both *sql.DB and *sql.Tx
+// for helpers that run on their own or as part of a larger transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// builds a WHERE clause out of optional conditions
+// values are always passed as placeholder parameters, only the conditions
+// themselves (which never contain user input) end up in the query text
+type where struct {
+	conditions []string
+	args       []any
+}
+
+// adds value to the query arguments and returns its placeholder
+func (w *where) arg(value any) string {
+	w.args = append(w.args, value)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func (w *where) add(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+// conditions joined with AND, TRUE when there are none
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return "TRUE"
+	}
+
+	return strings.Join(w.conditions, " AND ")
+}
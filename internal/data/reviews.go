@@ -0,0 +1,372 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// a user's rating of a movie, one per user and movie
+// hidden reviews are left out of the movie's rating and only shown to their author and moderators
+type Review struct {
+	ID           int64      `json:"id"`
+	MovieID      int64      `json:"movie_id"`
+	UserID       int        `json:"user_id"`
+	UserName     string     `json:"user_name"` // read only, taken from the author
+	Rating       int32      `json:"rating"`    // 1 to 10
+	Body         string     `json:"body,omitempty"`
+	HiddenAt     *time.Time `json:"hidden_at,omitzero"`
+	HiddenBy     *int       `json:"hidden_by,omitzero"` // nil once the moderator is deleted
+	HiddenReason string     `json:"hidden_reason,omitempty"`
+	Version      int32      `json:"version"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// columns selected whenever a full review is read, keep in sync with scanReview
+// the author's name comes from a subquery rather than a join so that
+// sort columns such as id and created_at aren't ambiguous
+const reviewColumns = "id, movie_id, user_id, (SELECT name FROM users WHERE users.id = reviews.user_id), rating, body, " +
+	"hidden_at, hidden_by, hidden_reason, version, created_at, updated_at"
+
+// prefix holds destinations for any columns selected before reviewColumns eg count(*) OVER()
+func scanReview(row interface{ Scan(...any) error }, review *Review, prefix ...any) error {
+	dest := append(prefix,
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.HiddenAt,
+		&review.HiddenBy,
+		&review.HiddenReason,
+		&review.Version,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+	)
+
+	return row.Scan(dest...)
+}
+
+func ValidateReview(v *validator.Validator, r *Review) {
+	v.Check(r.Rating >= 1 && r.Rating <= 10, "rating", "must be between 1 and 10")
+	v.Check(len(r.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+func ValidateHideReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// recomputes the rating aggregates of the movies from their visible reviews
+// runs inside the transaction that changes the reviews so the aggregates never drift
+func refreshRatings(ctx context.Context, tx *sql.Tx, movieIDs []int64) error {
+	query := `
+		UPDATE movies
+		SET rating_count = ratings.count, rating_average = ratings.average
+		FROM (
+			SELECT ids.id, count(reviews.id) AS count, COALESCE(round(avg(reviews.rating), 2), 0) AS average
+			FROM unnest($1::bigint[]) AS ids (id)
+			LEFT JOIN reviews ON reviews.movie_id = ids.id AND reviews.hidden_at IS NULL
+			GROUP BY ids.id
+		) AS ratings
+		WHERE movies.id = ratings.id
+	`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(movieIDs))
+	return err
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// runs fn and refreshes the movie's rating in a single transaction
+func (m ReviewModel) transaction(movieID int64, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = refreshRatings(ctx, tx, []int64{movieID})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO reviews (movie_id, user_id, rating, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, user_id, version, created_at, updated_at
+		)
+		SELECT inserted.id, users.name, inserted.version, inserted.created_at, inserted.updated_at
+		FROM inserted
+		INNER JOIN users ON users.id = inserted.user_id
+	`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	return m.transaction(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(
+			&review.ID,
+			&review.UserName,
+			&review.Version,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+		)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// reviews are only reachable through their movie so the movie id has to match as well
+// hidden reviews are returned too, it's up to the caller who gets to see them
+func (m ReviewModel) Get(movieID, id int) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM reviews
+		WHERE movie_id = $1
+		AND id = $2
+	`, reviewColumns)
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanReview(m.DB.QueryRowContext(ctx, query, movieID, id), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// like Get but looks the review up by id alone, used by moderators
+func (m ReviewModel) GetByID(id int) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM reviews
+		WHERE id = $1
+	`, reviewColumns)
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanReview(m.DB.QueryRowContext(ctx, query, id), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// hidden reviews are only listed when includeHidden is set
+func (m ReviewModel) GetAllForMovie(movieID int, includeHidden bool, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM reviews
+		WHERE movie_id = $1
+		AND (hidden_at IS NULL OR $2)
+		ORDER BY %s
+		LIMIT $3 OFFSET $4
+	`, reviewColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, includeHidden, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+
+		err := scanReview(rows, &review, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// every review the user has written, hidden ones included
+func (m ReviewModel) GetAllForUser(userID int) ([]*Review, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM reviews
+		WHERE user_id = $1
+		ORDER BY id
+	`, reviewColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+
+		err := scanReview(rows, &review)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version
+	`
+
+	args := []any{review.Rating, review.Body, review.ID, review.Version}
+
+	return m.transaction(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m ReviewModel) Delete(review *Review) error {
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1
+	`
+
+	return m.transaction(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, review.ID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// hides the review from everyone but its author and moderators, moderatorID is recorded as the one who hid it
+// an empty reason makes the review visible again
+func (m ReviewModel) SetHidden(review *Review, moderatorID int, reason string) error {
+	query := `
+		UPDATE reviews
+		SET hidden_at = CASE WHEN $1 = '' THEN NULL ELSE NOW() END,
+			hidden_by = CASE WHEN $1 = '' THEN NULL ELSE NULLIF($2, 0) END,
+			hidden_reason = $1,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $3
+		RETURNING hidden_at, hidden_by, hidden_reason, updated_at, version
+	`
+
+	return m.transaction(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, reason, moderatorID, review.ID).Scan(
+			&review.HiddenAt,
+			&review.HiddenBy,
+			&review.HiddenReason,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
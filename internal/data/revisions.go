@@ -0,0 +1,230 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// consts for the change a revision records
+const (
+	RevisionActionCreate   = "create"
+	RevisionActionUpdate   = "update"
+	RevisionActionRollback = "rollback"
+	RevisionActionDelete   = "delete"
+	RevisionActionRestore  = "restore"
+	RevisionActionImport   = "import" // baseline of a movie that existed before revisions were recorded
+)
+
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// immutable record of a movie at a given version
+// there are no update or delete methods on purpose
+type MovieRevision struct {
+	ID        int64                  `json:"id"`
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	Action    string                 `json:"action"`
+	ChangedBy *int                   `json:"changed_by"` // nil once the user who made the change is deleted
+	Snapshot  json.RawMessage        `json:"snapshot"`
+	Diff      map[string]FieldChange `json:"diff"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// decodes the snapshot back into the movie it was taken from
+func (r *MovieRevision) Movie() (*Movie, error) {
+	var movie Movie
+
+	err := json.Unmarshal(r.Snapshot, &movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// field level changes between two versions of a movie
+// before is nil for a newly created movie
+func diffMovies(before, after *Movie) map[string]FieldChange {
+	if before == nil {
+		before = &Movie{}
+	}
+
+	diff := make(map[string]FieldChange)
+
+	if before.Title != after.Title {
+		diff["title"] = FieldChange{From: before.Title, To: after.Title}
+	}
+	if before.Year != after.Year {
+		diff["year"] = FieldChange{From: before.Year, To: after.Year}
+	}
+	if before.Runtime != after.Runtime {
+		diff["runtime"] = FieldChange{From: before.Runtime, To: after.Runtime}
+	}
+	if !slices.Equal(before.Genres, after.Genres) {
+		diff["genres"] = FieldChange{From: before.Genres, To: after.Genres}
+	}
+	if before.ExternalIDs != after.ExternalIDs {
+		diff["external_ids"] = FieldChange{From: before.ExternalIDs, To: after.ExternalIDs}
+	}
+
+	return diff
+}
+
+// runs inside the transaction that changes the movie
+// so that a movie never changes without a matching revision
+func insertRevision(ctx context.Context, tx *sql.Tx, movie *Movie, action string, diff map[string]FieldChange, userID int) error {
+	snapshot, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, action, changed_by, snapshot, diff)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6)
+	`
+
+	args := []any{movie.ID, movie.Version, action, userID, snapshot, js}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// create revisions for a batch of newly inserted movies in a single statement
+func insertCreateRevisions(ctx context.Context, tx *sql.Tx, movies []*Movie, userID int) error {
+	values := make([]string, len(movies))
+	args := make([]any, 0, len(movies)*5+1)
+	args = append(args, userID)
+
+	for i, movie := range movies {
+		snapshot, err := json.Marshal(movie)
+		if err != nil {
+			return err
+		}
+
+		diff, err := json.Marshal(diffMovies(nil, movie))
+		if err != nil {
+			return err
+		}
+
+		n := len(args)
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, NULLIF($1, 0), $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, movie.ID, movie.Version, RevisionActionCreate, snapshot, diff)
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, action, changed_by, snapshot, diff)
+		VALUES ` + strings.Join(values, ", ")
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+func (m MovieRevisionModel) GetAll(movieID int, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, version, action, changed_by, snapshot, diff, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3
+	`, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := scanRevision(rows, &revision, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+func (m MovieRevisionModel) Get(movieID, version int) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, movie_id, version, action, changed_by, snapshot, diff, created_at
+		FROM movie_revisions
+		WHERE movie_id = $1
+		AND version = $2
+	`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanRevision(m.DB.QueryRowContext(ctx, query, movieID, version), &revision)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// prefix holds destinations for any columns selected before the revision columns
+func scanRevision(row interface{ Scan(...any) error }, revision *MovieRevision, prefix ...any) error {
+	var diff []byte
+
+	dest := append(prefix,
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.ChangedBy,
+		&revision.Snapshot,
+		&diff,
+		&revision.CreatedAt,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(diff, &revision.Diff)
+}
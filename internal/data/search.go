@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/validator"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// text search configurations a search can use
+// movies_title_idx and movies_title_english_idx cover simple and english
+var SearchLanguages = []string{"simple", "english", "french", "german", "spanish"}
+
+type MovieSearch struct {
+	Query    string
+	Language string
+}
+
+// movie matched by a search along with how well it matched
+type MovieSearchResult struct {
+	*Movie
+	Relevance float64 `json:"relevance"`
+	Highlight string  `json:"highlight"` // HTML escaped title with the matched words wrapped in <mark></mark>
+}
+
+func ValidateMovieSearch(v *validator.Validator, s MovieSearch) {
+	v.Check(s.Query != "", "q", "must be provided")
+	v.Check(len(s.Query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(s.Query == "" || s.prefixQuery() != "", "q", "must contain at least one letter or digit")
+	v.Check(validator.PermittedValue(s.Language, SearchLanguages...), "lang", fmt.Sprintf("must be one of %s", strings.Join(SearchLanguages, ", ")))
+}
+
+// ts_headline marks matches with characters from the private use area instead of <mark></mark>
+// so that the title can be HTML escaped before the marks are turned into tags
+// any such characters in the title itself are dropped beforehand
+const (
+	highlightStart  = "\ue000"
+	highlightStop   = "\ue001"
+	headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+)
+
+var highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// escapes the title so that markup in it is shown as text and wraps the matches in <mark></mark>
+func highlightHTML(headline string) string {
+	return highlightReplacer.Replace(html.EscapeString(headline))
+}
+
+// tsquery that matches every word of the query as a prefix eg "godfa par" -> godfa:* & par:*
+// anything other than letters and digits is dropped so that user input
+// can't inject tsquery operators
+func (s MovieSearch) prefixQuery() string {
+	words := strings.FieldsFunc(s.Query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// full text matches are found with the language's tsvector and ranked with ts_rank
+// typos and partial words are caught by pg_trgm word similarity which is added to the rank
+// filter narrows down the matches the same way it does for GetAll, its Title is ignored
+func (m MovieModel) Search(search MovieSearch, filter MovieFilter, filters Filters) ([]*MovieSearchResult, Metadata, error) {
+	var w where
+
+	// the language is one of SearchLanguages so it's safe to put in the query
+	// keeping it a literal lets postgres use the tsvector indexes
+	language := search.Language
+	text := w.arg(search.Query)
+	tsquery := fmt.Sprintf("to_tsquery('%s', %s)", language, w.arg(search.prefixQuery()))
+	tsvector := fmt.Sprintf("to_tsvector('%s', title)", language)
+
+	filter.Title = ""
+	filter.where(&w)
+	// <% is true when the query is similar enough to a word in the title
+	w.add(fmt.Sprintf("(%s @@ %s OR %s <%% title)", tsvector, tsquery, text))
+
+	orderBy := "relevance DESC, id ASC"
+	if filters.Sort != "relevance" {
+		orderBy = filters.orderBy(false)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(),
+			ts_rank(%[1]s, %[2]s) + word_similarity(%[3]s, title) AS relevance,
+			ts_headline('%[4]s', translate(title, %[10]s, ''), %[2]s, %[11]s) AS highlight,
+			%[5]s
+		FROM movies
+		WHERE %[6]s
+		ORDER BY %[7]s
+		LIMIT %[8]s OFFSET %[9]s
+	`, tsvector, tsquery, text, language, movieColumns, w.String(), orderBy, w.arg(filters.limit()), w.arg(filters.offset()),
+		w.arg(highlightStart+highlightStop), w.arg(headlineOptions))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*MovieSearchResult{}
+	for rows.Next() {
+		result := MovieSearchResult{Movie: &Movie{}}
+		err := scanMovie(rows, result.Movie, &totalRecords, &result.Relevance, &result.Highlight)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		result.Highlight = highlightHTML(result.Highlight)
+		results = append(results, &result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return results, metadata, nil
+}
+
+// just enough of a movie to show in a search box
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitzero"`
+}
+
+func ValidateSuggestPrefix(v *validator.Validator, prefix string) {
+	v.Check(len(prefix) >= 2, "prefix", "must be at least 2 bytes long")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(MovieSearch{Query: prefix}.prefixQuery() != "", "prefix", "must contain at least one letter or digit")
+}
+
+// titles starting with prefix come first, followed by titles with a word starting with it
+// shorter titles are ranked higher since they're closer to what has been typed so far
+// the first case uses movies_title_prefix_idx and the second movies_title_idx
+func (m MovieModel) Suggest(prefix string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+		SELECT id, title, year
+		FROM movies
+		WHERE deleted_at IS NULL
+		AND (lower(title) LIKE $1 OR to_tsvector('simple', title) @@ to_tsquery('simple', $2))
+		ORDER BY lower(title) LIKE $1 DESC, length(title), title, id
+		LIMIT $3
+	`
+
+	// % and _ are LIKE wildcards so they have to be escaped
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(prefix)) + "%"
+	args := []any{pattern, MovieSearch{Query: prefix}.prefixQuery(), limit}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+	for rows.Next() {
+		var suggestion MovieSuggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -14,6 +14,8 @@ const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
 	ScopePasswordReset  = "password-reset"
+	ScopeDataExport     = "data-export"
+	ScopeImpersonation  = "impersonation"
 )
 
 func ValidatePlainTextToken(v *validator.Validator, token string) {
@@ -24,10 +26,18 @@ type Token struct {
 	PlainText string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	userID    int       `json:"-"`
+	actorID   int       `json:"-"` // admin acting as userID, zero unless scope is impersonation
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
 }
 
+// token details that are safe to hand back to the user
+// the plaintext is never stored so it can't be part of it
+type TokenMetadata struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
 func generateToken(userID int, ttl time.Duration, scope string) *Token {
 	token := &Token{
 		PlainText: rand.Text(),
@@ -52,13 +62,22 @@ func (m TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, er
 	return token, err
 }
 
+// short-lived token that authenticates actorID as userID
+func (m TokenModel) NewImpersonation(userID, actorID int, ttl time.Duration) (*Token, error) {
+	token := generateToken(userID, ttl, ScopeImpersonation)
+	token.actorID = actorID
+
+	err := m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tokens (hash, user_id, expiry, scope, actor_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
 	`
 
-	args := []any{token.Hash, token.userID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.userID, token.Expiry, token.Scope, token.actorID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -87,3 +106,37 @@ func (m TokenModel) Delete(scope string, userID int) error {
 
 	return err
 }
+
+func (m TokenModel) GetAllForUser(userID int) ([]TokenMetadata, error) {
+	query := `
+		SELECT scope, expiry
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY expiry DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []TokenMetadata{}
+	for rows.Next() {
+		var token TokenMetadata
+		err := rows.Scan(&token.Scope, &token.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
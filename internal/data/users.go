@@ -8,6 +8,7 @@ import (
 	"greenlight/internal/validator"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,20 +18,59 @@ var (
 
 var AnonymousUser = &User{}
 
+type UserStatus string
+
+// consts for account status
+const (
+	StatusActive          UserStatus = "active"
+	StatusSuspended       UserStatus = "suspended"
+	StatusPendingDeletion UserStatus = "pending-deletion"
+)
+
 type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	CreatedAt time.Time `json:"created_at"`
-	Version   int       `json:"-"`
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	Password     password   `json:"-"`
+	Activated    bool       `json:"activated"`
+	Status       UserStatus `json:"status"`
+	StatusReason string     `json:"status_reason,omitempty"`
+	StatusUntil  *time.Time `json:"status_until,omitzero"` // nil means the status doesn't expire
+	CreatedAt    time.Time  `json:"created_at"`
+	Version      int        `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// a suspension with an until-time lifts itself once that time has passed
+// so we don't need a job to flip the status back to active
+func (u *User) IsSuspended() bool {
+	if u.Status != StatusSuspended {
+		return false
+	}
+
+	return u.StatusUntil == nil || u.StatusUntil.After(time.Now())
+}
+
+func (u *User) IsPendingDeletion() bool {
+	return u.Status == StatusPendingDeletion
+}
+
+// moves the account to the given status
+// reason and until are cleared when status is active
+func (u *User) SetStatus(status UserStatus, reason string, until *time.Time) {
+	u.Status = status
+	u.StatusReason = reason
+	u.StatusUntil = until
+
+	if status == StatusActive {
+		u.StatusReason = ""
+		u.StatusUntil = nil
+	}
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -69,23 +109,42 @@ func ValidateUser(v *validator.Validator, user *User) map[string]string {
 	return nil
 }
 
+func ValidateSuspension(v *validator.Validator, reason string, until *time.Time) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	if until != nil {
+		v.Check(until.After(time.Now()), "until", "must be in the future")
+	}
+}
+
 // wraps connection pool
 type UserModel struct {
 	DB *sql.DB
 }
 
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+// db is either the connection pool or a transaction the user is created in
+func insertUser(ctx context.Context, db querier, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password, activated)
-		VALUES($1, $2, $3, $4)
+		INSERT INTO users (name, email, password, activated, status)
+		VALUES($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version
 	`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	if user.Status == "" {
+		user.Status = StatusActive
+	}
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Status}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -98,10 +157,50 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, name, email, password, activated, status, status_reason, status_until, created_at, version
+		FROM users
+		WHERE id = $1
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Status,
+		&user.StatusReason,
+		&user.StatusUntil,
+		&user.CreatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, name, email, password, activated, created_at, version 
-		FROM users 
+		SELECT id, name, email, password, activated, status, status_reason, status_until, created_at, version
+		FROM users
 		WHERE email = $1
 	`
 
@@ -116,6 +215,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Status,
+		&user.StatusReason,
+		&user.StatusUntil,
 		&user.CreatedAt,
 		&user.Version,
 	)
@@ -135,8 +237,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password = $3, activated = $4, status = $5, status_reason = $6, status_until = $7, version = version + 1
+		WHERE id = $8 AND version = $9
 		RETURNING version
 	`
 
@@ -145,6 +247,9 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Status,
+		user.StatusReason,
+		user.StatusUntil,
 		user.ID,
 		user.Version,
 	}
@@ -173,7 +278,7 @@ func (m UserModel) GetUserByToken(tokenScope, tokenPlainText string) (*User, err
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
 	query := `
-		SELECT users.id, users.name, users.email, users.password, users.activated, users.created_at, users.version
+		SELECT users.id, users.name, users.email, users.password, users.activated, users.status, users.status_reason, users.status_until, users.created_at, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -196,6 +301,9 @@ func (m UserModel) GetUserByToken(tokenScope, tokenPlainText string) (*User, err
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Status,
+		&user.StatusReason,
+		&user.StatusUntil,
 		&user.CreatedAt,
 		&user.Version,
 	)
@@ -211,6 +319,99 @@ func (m UserModel) GetUserByToken(tokenScope, tokenPlainText string) (*User, err
 	return &user, nil
 }
 
+// resolves a bearer token which can either be an authentication or an impersonation token
+// for impersonation tokens the returned user is the impersonated user and actorID the admin behind the request
+// actorID is zero otherwise
+func (m UserModel) GetUserByBearerToken(tokenPlainText string) (*User, int, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+		SELECT users.id, users.name, users.email, users.password, users.activated, users.status, users.status_reason, users.status_until, users.created_at, users.version, COALESCE(tokens.actor_id, 0)
+		FROM users
+		INNER JOIN tokens
+		ON users.id = tokens.user_id
+		WHERE tokens.hash = $1
+		AND tokens.scope IN ('authentication', 'impersonation')
+		AND tokens.expiry > $2
+	`
+
+	var (
+		user    User
+		actorID int
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Status,
+		&user.StatusReason,
+		&user.StatusUntil,
+		&user.CreatedAt,
+		&user.Version,
+		&actorID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+
+	return &user, actorID, nil
+}
+
+// permanently removes accounts whose deletion grace period has elapsed
+// tokens, permissions and exports go with them through ON DELETE CASCADE
+func (m UserModel) DeletePendingDeletion() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// reviews go along with their authors so the movies they reviewed need their ratings refreshed
+	// the select still sees the reviews since the cascade only runs at the end of the statement
+	query := `
+		WITH deleted AS (
+			DELETE FROM users
+			WHERE status = 'pending-deletion'
+			AND status_until <= $1
+			RETURNING id
+		)
+		SELECT count(DISTINCT deleted.id), COALESCE(array_agg(DISTINCT reviews.movie_id) FILTER (WHERE reviews.movie_id IS NOT NULL), '{}')
+		FROM deleted
+		LEFT JOIN reviews ON reviews.user_id = deleted.id
+	`
+
+	var (
+		deleted  int64
+		movieIDs []int64
+	)
+
+	err = tx.QueryRowContext(ctx, query, time.Now()).Scan(&deleted, pq.Array(&movieIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	err = refreshRatings(ctx, tx, movieIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+)
+
+var ErrDuplicateWatchlistItem = errors.New("duplicate watchlist item")
+
+// a movie on a user's watchlist
+type WatchlistItem struct {
+	Position int32     `json:"position"` // 1 is the top of the list
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// a single viewing of a movie, the same movie can be watched several times
+type WatchedEntry struct {
+	ID        int64  `json:"id"`
+	WatchedOn Date   `json:"watched_on"`
+	Movie     *Movie `json:"movie"`
+}
+
+func ValidateWatchedOn(v *validator.Validator, watchedOn Date) {
+	v.Check(watchedOn.Year() >= 1888, "watched_on", "must be after 1888")
+	// a day of leeway for users ahead of UTC
+	v.Check(!watchedOn.After(Today().AddDate(0, 0, 1)), "watched_on", "must not be in the future")
+}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// serialises changes to the user's watchlist so that positions stay consecutive
+// NO KEY UPDATE doesn't block inserts referencing the user elsewhere
+func lockWatchlist(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR NO KEY UPDATE", userID)
+	return err
+}
+
+// runs fn on the locked watchlist of the user in a single transaction
+func (m WatchlistModel) transaction(userID int, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// movies on the user's watchlist that match the filter
+// movies in the trash keep their place but aren't listed
+func (m WatchlistModel) GetAll(userID int, movieFilter MovieFilter, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	var w where
+	w.add("watchlist_items.user_id = " + w.arg(userID))
+	movieFilter.where(&w)
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watchlist_items.position, watchlist_items.added_at, %s
+		FROM watchlist_items
+		INNER JOIN movies ON movies.id = watchlist_items.movie_id
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, movieColumns, w.String(), filters.orderBy(false), w.arg(filters.limit()), w.arg(filters.offset()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+	for rows.Next() {
+		item := WatchlistItem{Movie: &Movie{}}
+
+		err := scanMovie(rows, item.Movie, &totalRecords, &item.Position, &item.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// the whole watchlist, movies in the trash included
+func (m WatchlistModel) GetAllForUser(userID int) ([]*WatchlistItem, error) {
+	query := fmt.Sprintf(`
+		SELECT watchlist_items.position, watchlist_items.added_at, %s
+		FROM watchlist_items
+		INNER JOIN movies ON movies.id = watchlist_items.movie_id
+		WHERE watchlist_items.user_id = $1
+		ORDER BY watchlist_items.position
+	`, movieColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*WatchlistItem{}
+	for rows.Next() {
+		item := WatchlistItem{Movie: &Movie{}}
+
+		err := scanMovie(rows, item.Movie, &item.Position, &item.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+var watchlistOrder = orderedMovies{table: "watchlist_items", owner: "user_id"}
+
+// puts the movie at the position, the movies from there on move down one place
+// the position is clamped to the list, 0 adds the movie to the end
+func (m WatchlistModel) Add(userID int, item *WatchlistItem) error {
+	return m.transaction(userID, func(ctx context.Context, tx *sql.Tx) error {
+		position, err := watchlistOrder.makeRoom(ctx, tx, int64(userID), item.Position)
+		if err != nil {
+			return err
+		}
+		item.Position = position
+
+		query := `
+			INSERT INTO watchlist_items (user_id, movie_id, position)
+			VALUES ($1, $2, $3)
+			RETURNING added_at
+		`
+
+		err = tx.QueryRowContext(ctx, query, userID, item.Movie.ID, item.Position).Scan(&item.AddedAt)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_items_pkey"`:
+				return ErrDuplicateWatchlistItem
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// moves the movie to the position, the movies in between shift by one place to make room
+// the position is clamped to the list
+func (m WatchlistModel) Move(userID int, item *WatchlistItem) error {
+	return m.transaction(userID, func(ctx context.Context, tx *sql.Tx) error {
+		position, err := watchlistOrder.move(ctx, tx, int64(userID), item.Movie.ID, item.Position)
+		if err != nil {
+			return err
+		}
+		item.Position = position
+
+		query := `
+			SELECT added_at
+			FROM watchlist_items
+			WHERE user_id = $1
+			AND movie_id = $2
+		`
+
+		return tx.QueryRowContext(ctx, query, userID, item.Movie.ID).Scan(&item.AddedAt)
+	})
+}
+
+// the movies below the removed one move up one place
+func (m WatchlistModel) Remove(userID int, movieID int) error {
+	return m.transaction(userID, func(ctx context.Context, tx *sql.Tx) error {
+		return watchlistOrder.remove(ctx, tx, int64(userID), int64(movieID))
+	})
+}
+
+type WatchedModel struct {
+	DB *sql.DB
+}
+
+func (m WatchedModel) Insert(userID int, entry *WatchedEntry) error {
+	query := `
+		INSERT INTO watched_movies (user_id, movie_id, watched_on)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, userID, entry.Movie.ID, entry.WatchedOn.String()).Scan(&entry.ID)
+}
+
+// the user's viewings, most recent first unless Sort is watched_on
+// viewings of movies in the trash aren't listed
+func (m WatchedModel) GetAll(userID int, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	direction := "DESC"
+	if filters.Sort == "watched_on" {
+		direction = "ASC"
+	}
+
+	// the entry's id is renamed so that it doesn't clash with the movie's
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), watched.entry_id, watched.watched_on, %s
+		FROM (
+			SELECT id AS entry_id, movie_id, watched_on
+			FROM watched_movies
+			WHERE user_id = $1
+		) AS watched
+		INNER JOIN movies ON movies.id = watched.movie_id
+		WHERE deleted_at IS NULL
+		ORDER BY watched.watched_on %[2]s, watched.entry_id %[2]s
+		LIMIT $2 OFFSET $3
+	`, movieColumns, direction)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+	for rows.Next() {
+		entry := WatchedEntry{Movie: &Movie{}}
+
+		err := scanMovie(rows, entry.Movie, &totalRecords, &entry.ID, &entry.WatchedOn.Time)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// every viewing of the user, movies in the trash included
+func (m WatchedModel) GetAllForUser(userID int) ([]*WatchedEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT watched.entry_id, watched.watched_on, %s
+		FROM (
+			SELECT id AS entry_id, movie_id, watched_on
+			FROM watched_movies
+			WHERE user_id = $1
+		) AS watched
+		INNER JOIN movies ON movies.id = watched.movie_id
+		ORDER BY watched.watched_on, watched.entry_id
+	`, movieColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*WatchedEntry{}
+	for rows.Next() {
+		entry := WatchedEntry{Movie: &Movie{}}
+
+		err := scanMovie(rows, entry.Movie, &entry.ID, &entry.WatchedOn.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (m WatchedModel) Delete(userID int, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM watched_movies
+		WHERE user_id = $1
+		AND id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,327 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// a test operation found a value other than the one it expected
+	ErrTestFailed = errors.New("test operation failed")
+	// the patch refers to a location that doesn't exist in the document
+	ErrPathNotFound = errors.New("path not found")
+	// the patch itself is malformed eg unknown op or missing value
+	ErrInvalidOperation = errors.New("invalid operation")
+)
+
+// RFC 7396 merge patch
+// objects in the patch are merged into the document recursively, null removes a member
+// and anything else replaces the target value as a whole
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p any
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = merge(t[key], value)
+	}
+
+	return t
+}
+
+// RFC 6902 operation
+// Value is nil when the operation has no value member, null is kept as json null
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applies the operations in order, if any of them fails the document is left as it was
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var node any
+
+	err := json.Unmarshal(doc, &node)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		node, err = apply(node, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(node)
+}
+
+func apply(node any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: value must be provided", ErrInvalidOperation)
+		}
+
+		var v any
+		err := json.Unmarshal(op.Value, &v)
+		return v, err
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, v)
+	case "remove":
+		return remove(node, path)
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return v, nil
+		}
+		node, err = remove(node, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, v)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := get(node, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			// the copy mustn't share maps or slices with the original
+			js, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(js, &v)
+			if err != nil {
+				return nil, err
+			}
+			return add(node, path, v)
+		}
+
+		// a value can't be moved into one of its own children
+		if len(from) < len(path) && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("%w: from must not be a prefix of path", ErrInvalidOperation)
+		}
+
+		node, err = remove(node, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(node, path, v)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := get(node, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reflect.DeepEqual(current, v) {
+			return nil, ErrTestFailed
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%w: op must be one of add, remove, replace, move, copy or test", ErrInvalidOperation)
+	}
+}
+
+// splits a JSON pointer (RFC 6901) into its reference tokens, the empty pointer refers to the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q is not a JSON pointer", ErrInvalidOperation, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// array index referred to by token
+// "-" and len are only valid when adding since they point past the last element
+func index(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+
+	// leading zeros aren't allowed
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: %q is not an array index", ErrInvalidOperation, token)
+	}
+
+	if i > length || (!adding && i == length) {
+		return 0, ErrPathNotFound
+	}
+
+	return i, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			node = child
+		case []any:
+			i, err := index(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+
+	return node, nil
+}
+
+// calls fn with the container the last token of path refers into and stores the
+// container fn returns back into its parent, since appending to a slice may reallocate it
+func modify(node any, path []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+
+		updated, err := modify(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = updated
+		return n, nil
+	case []any:
+		i, err := index(path[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := modify(n[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, ErrPathNotFound
+	}
+}
+
+func add(node any, path []string, value any) (any, error) {
+	// adding to the root replaces the whole document
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return modify(node, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			i, err := index(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], append([]any{value}, c[i:]...)...), nil
+		default:
+			return nil, ErrPathNotFound
+		}
+	})
+}
+
+func remove(node any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: the whole document can't be removed", ErrInvalidOperation)
+	}
+
+	return modify(node, path, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, ErrPathNotFound
+			}
+			delete(c, token)
+			return c, nil
+		case []any:
+			i, err := index(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, ErrPathNotFound
+		}
+	})
+}
@@ -0,0 +1,469 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// compares the documents as JSON values so that member order doesn't matter
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var g, w any
+
+	err := json.Unmarshal(got, &g)
+	if err != nil {
+		t.Fatalf("unmarshal result %q: %v", got, err)
+	}
+
+	err = json.Unmarshal([]byte(want), &w)
+	if err != nil {
+		t.Fatalf("unmarshal want %q: %v", want, err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+// RFC 7396 appendix A plus a couple of extras
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"null removes only that member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"string replaces array", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"array replaces string", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested merge", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"arrays replaced whole", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"array document", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"array patch replaces object", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"null patch replaces document", `{"a":"foo"}`, `null`, `null`},
+		{"string patch replaces document", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"null in document is kept", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"object patch replaces array", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"nulls dropped from new objects", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{"removing a missing member", `{"a":1}`, `{"b":null}`, `{"a":1}`},
+		{"empty patch", `{"a":{"b":1}}`, `{}`, `{"a":{"b":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergeInvalidJSON(t *testing.T) {
+	_, err := Merge([]byte(`{"a":`), []byte(`{}`))
+	if err == nil {
+		t.Error("expected an error for an invalid document")
+	}
+
+	_, err = Merge([]byte(`{}`), []byte(`{"a":`))
+	if err == nil {
+		t.Error("expected an error for an invalid patch")
+	}
+}
+
+// RFC 6902 appendix A plus the edge cases around pointers, indices and aliasing
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error // when set the patch must fail with it and want is ignored
+	}{
+		// appendix A
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "A.9 testing a value error",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+
+		// pointer escaping
+		{
+			name:  "~1 refers to a slash",
+			doc:   `{"a/b":1}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:  `{"a/b":2}`,
+		},
+		{
+			name:  "~0 refers to a tilde",
+			doc:   `{"m~n":1}`,
+			patch: `[{"op":"remove","path":"/m~0n"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "~0 followed by 1 isn't a slash",
+			doc:   `{"~1":1,"/":2}`,
+			patch: `[{"op":"remove","path":"/~01"}]`,
+			want:  `{"/":2}`,
+		},
+		{
+			name:  "empty member name",
+			doc:   `{"":1}`,
+			patch: `[{"op":"replace","path":"/","value":2}]`,
+			want:  `{"":2}`,
+		},
+		{
+			name:  "pointer without a leading slash",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":"a"}]`,
+			err:   ErrInvalidOperation,
+		},
+
+		// the root
+		{
+			name:  "replacing the root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"","value":[1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:  "adding to the root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"","value":{"b":2}}]`,
+			want:  `{"b":2}`,
+		},
+		{
+			name:  "removing the root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":""}]`,
+			err:   ErrInvalidOperation,
+		},
+
+		// array indices
+		{
+			name:  "- appends",
+			doc:   `[1,2]`,
+			patch: `[{"op":"add","path":"/-","value":3}]`,
+			want:  `[1,2,3]`,
+		},
+		{
+			name:  "adding at the length appends",
+			doc:   `[1,2]`,
+			patch: `[{"op":"add","path":"/2","value":3}]`,
+			want:  `[1,2,3]`,
+		},
+		{
+			name:  "adding at 0 prepends",
+			doc:   `[1,2]`,
+			patch: `[{"op":"add","path":"/0","value":0}]`,
+			want:  `[0,1,2]`,
+		},
+		{
+			name:  "adding past the length",
+			doc:   `[1,2]`,
+			patch: `[{"op":"add","path":"/3","value":3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "removing at the length",
+			doc:   `[1,2]`,
+			patch: `[{"op":"remove","path":"/2"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "replacing at the length",
+			doc:   `[1,2]`,
+			patch: `[{"op":"replace","path":"/2","value":3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "removing -",
+			doc:   `[1,2]`,
+			patch: `[{"op":"remove","path":"/-"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "testing -",
+			doc:   `[1,2]`,
+			patch: `[{"op":"test","path":"/-","value":2}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "negative index",
+			doc:   `[1,2]`,
+			patch: `[{"op":"remove","path":"/-1"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "leading zero",
+			doc:   `[1,2]`,
+			patch: `[{"op":"remove","path":"/01"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "index into a nested array",
+			doc:   `{"a":[[1],[2,3]]}`,
+			patch: `[{"op":"add","path":"/a/1/1","value":9}]`,
+			want:  `{"a":[[1],[2,9,3]]}`,
+		},
+
+		// move
+		{
+			name:  "moving into its own child",
+			doc:   `{"a":{"b":{}}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "moving to a sibling sharing a prefix",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/ab"}]`,
+			want:  `{"ab":1}`,
+		},
+		{
+			name:  "moving onto itself",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:  `{"a":{"b":1}}`,
+		},
+		{
+			name:  "moving from a missing location",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","from":"/b","path":"/c"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "moving an array element to the end",
+			doc:   `[1,2,3]`,
+			patch: `[{"op":"move","from":"/0","path":"/-"}]`,
+			want:  `[2,3,1]`,
+		},
+
+		// test compares JSON values
+		{
+			name:  "numbers compare by value",
+			doc:   `{"n":1}`,
+			patch: `[{"op":"test","path":"/n","value":1.0}]`,
+			want:  `{"n":1}`,
+		},
+		{
+			name:  "exponent notation",
+			doc:   `{"n":100}`,
+			patch: `[{"op":"test","path":"/n","value":1e2}]`,
+			want:  `{"n":100}`,
+		},
+		{
+			name:  "objects compare regardless of member order",
+			doc:   `{"o":{"a":[1,{"b":2}],"c":null}}`,
+			patch: `[{"op":"test","path":"/o","value":{"c":null,"a":[1.0,{"b":2}]}}]`,
+			want:  `{"o":{"a":[1,{"b":2}],"c":null}}`,
+		},
+		{
+			name:  "arrays compare in order",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"test","path":"/a","value":[2,1]}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "null isn't a missing value",
+			doc:   `{"a":null}`,
+			patch: `[{"op":"test","path":"/a","value":null}]`,
+			want:  `{"a":null}`,
+		},
+		{
+			name:  "testing a missing location",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/b","value":1}]`,
+			err:   ErrPathNotFound,
+		},
+
+		// aliasing, later operations mustn't see through to values an earlier one copied or shifted
+		{
+			name:  "changing a copied object leaves the original alone",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want:  `{"a":{"b":1},"c":{"b":2}}`,
+		},
+		{
+			name:  "adding to a copied array leaves the original alone",
+			doc:   `{"a":[1,2,3]}`,
+			patch: `[{"op":"copy","from":"/a","path":"/b"},{"op":"add","path":"/b/1","value":9},{"op":"remove","path":"/b/0"}]`,
+			want:  `{"a":[1,2,3],"b":[9,2,3]}`,
+		},
+		{
+			name:  "removing from a copied array leaves the original alone",
+			doc:   `{"a":[1,2,3]}`,
+			patch: `[{"op":"copy","from":"/a","path":"/b"},{"op":"remove","path":"/a/0"}]`,
+			want:  `{"a":[2,3],"b":[1,2,3]}`,
+		},
+		{
+			name:  "removing then adding within one array",
+			doc:   `{"a":[1,2,3,4]}`,
+			patch: `[{"op":"remove","path":"/a/1"},{"op":"add","path":"/a/1","value":5},{"op":"add","path":"/a/-","value":6}]`,
+			want:  `{"a":[1,5,3,4,6]}`,
+		},
+		{
+			name:  "copying an array element into the same array",
+			doc:   `{"a":[[1],[2]]}`,
+			patch: `[{"op":"copy","from":"/a/0","path":"/a/0"},{"op":"add","path":"/a/0/-","value":9}]`,
+			want:  `{"a":[[1,9],[1],[2]]}`,
+		},
+
+		// malformed operations
+		{
+			name:  "unknown op",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"increment","path":"/a"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "add without a value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/b"}]`,
+			err:   ErrInvalidOperation,
+		},
+		{
+			name:  "add with a null value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/b","value":null}]`,
+			want:  `{"a":1,"b":null}`,
+		},
+		{
+			name:  "replacing a missing member",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"/b","value":2}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "removing a missing member",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":"/b"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "pointing into a scalar",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/a/b","value":2}]`,
+			err:   ErrPathNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+
+			err := json.Unmarshal([]byte(tt.patch), &ops)
+			if err != nil {
+				t.Fatalf("unmarshal patch: %v", err)
+			}
+
+			got, err := Apply([]byte(tt.doc), ops)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v; want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
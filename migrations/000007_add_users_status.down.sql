@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'users:admin';
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_status_check,
+    DROP COLUMN IF EXISTS status_until,
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_pending_deletion_idx;
+DROP TABLE IF EXISTS user_exports;
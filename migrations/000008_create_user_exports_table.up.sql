@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS user_exports (
+    user_id BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    archive JSONB NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS users_pending_deletion_idx ON users (status_until) WHERE status = 'pending-deletion';
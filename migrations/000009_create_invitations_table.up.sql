@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    hash BYTEA UNIQUE NOT NULL,
+    email CITEXT NOT NULL,
+    permissions TEXT[] NOT NULL,
+    invited_by BIGINT REFERENCES users ON DELETE SET NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
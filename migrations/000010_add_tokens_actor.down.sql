@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'users:impersonate';
+
+DELETE FROM tokens WHERE scope = 'impersonation';
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS actor_id;
@@ -0,0 +1,6 @@
+-- set on impersonation tokens to record the admin acting on behalf of user_id
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS actor_id BIGINT REFERENCES users ON DELETE CASCADE;
+
+INSERT INTO permissions (code)
+VALUES
+    ('users:impersonate');
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id BIGINT NOT NULL, -- deliberately not a foreign key, revisions outlive the movie
+    version INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    changed_by BIGINT REFERENCES users ON DELETE SET NULL,
+    snapshot JSONB NOT NULL,
+    diff JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (movie_id, version)
+);
+
+-- a baseline revision for every existing movie so that history starts at its current version
+-- the snapshot mirrors how data.Movie is marshalled
+INSERT INTO movie_revisions (movie_id, version, action, snapshot, created_at)
+SELECT id, version, 'import',
+    jsonb_build_object(
+        'id', id,
+        'title', title,
+        'year', year,
+        'runtime', runtime || ' mins',
+        'genres', genres,
+        'version', version
+    ),
+    created_at
+FROM movies
+ON CONFLICT (movie_id, version) DO NOTHING;
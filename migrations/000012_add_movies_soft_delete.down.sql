@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+
+-- trashed movies would otherwise reappear
+DELETE FROM movies WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS movies_deleted_at_idx;
+
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS deleted_by,
+    DROP COLUMN IF EXISTS deleted_at;
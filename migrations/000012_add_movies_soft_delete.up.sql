@@ -0,0 +1,9 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_by BIGINT REFERENCES users ON DELETE SET NULL;
+
+-- only trashed movies are indexed, keeps the index small
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO permissions (code)
+VALUES
+    ('movies:admin');
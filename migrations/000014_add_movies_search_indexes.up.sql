@@ -0,0 +1,4 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN(title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN(to_tsvector('english', title));
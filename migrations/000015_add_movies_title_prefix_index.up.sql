@@ -0,0 +1,2 @@
+-- text_pattern_ops lets LIKE 'prefix%' use the index regardless of the database collation
+CREATE INDEX IF NOT EXISTS movies_title_prefix_idx ON movies (lower(title) text_pattern_ops) WHERE deleted_at IS NULL;
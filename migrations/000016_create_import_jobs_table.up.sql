@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    status TEXT NOT NULL DEFAULT 'pending',
+    format TEXT NOT NULL,
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    valid_rows INTEGER NOT NULL DEFAULT 0,
+    imported_rows INTEGER NOT NULL DEFAULT 0,
+    errors JSONB NOT NULL DEFAULT '[]',
+    created_by BIGINT REFERENCES users ON DELETE SET NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP(0) WITH TIME ZONE
+);
@@ -0,0 +1,8 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_id TEXT;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tmdb_id TEXT;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS wikidata_id TEXT;
+
+-- NULLs never clash so movies without an external id are unaffected
+ALTER TABLE movies ADD CONSTRAINT movies_imdb_id_key UNIQUE (imdb_id);
+ALTER TABLE movies ADD CONSTRAINT movies_tmdb_id_key UNIQUE (tmdb_id);
+ALTER TABLE movies ADD CONSTRAINT movies_wikidata_id_key UNIQUE (wikidata_id);
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS people (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    name TEXT NOT NULL,
+    birth_year INTEGER,
+    imdb_id TEXT,
+    tmdb_id TEXT,
+    wikidata_id TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT people_imdb_id_key UNIQUE (imdb_id),
+    CONSTRAINT people_tmdb_id_key UNIQUE (tmdb_id),
+    CONSTRAINT people_wikidata_id_key UNIQUE (wikidata_id)
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS credits (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    -- people can't be deleted while they're still credited
+    person_id BIGINT NOT NULL REFERENCES people ON DELETE RESTRICT,
+    role TEXT NOT NULL CHECK (role IN ('director', 'writer', 'actor')),
+    character TEXT NOT NULL DEFAULT '', -- empty for anyone but actors
+    billing_order INTEGER CHECK (billing_order > 0),
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- an actor may play several characters in the same movie
+    CONSTRAINT credits_movie_person_role_character_key UNIQUE (movie_id, person_id, role, character)
+);
+
+-- the movie side is covered by the unique constraint
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id, role);
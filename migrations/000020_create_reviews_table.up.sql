@@ -0,0 +1,26 @@
+-- aggregates over the visible reviews, kept up to date by every review change
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_average NUMERIC(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_rating_average_idx ON movies (rating_average, id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body TEXT NOT NULL DEFAULT '',
+    hidden_at TIMESTAMP(0) WITH TIME ZONE, -- set when a moderator hides the review
+    hidden_by BIGINT REFERENCES users ON DELETE SET NULL,
+    hidden_reason TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('reviews:moderate');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS watched_movies;
+DROP TABLE IF EXISTS watchlist_items;
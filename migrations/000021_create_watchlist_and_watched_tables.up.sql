@@ -0,0 +1,23 @@
+-- column names must not clash with those of movies since the two are joined without qualifying movieColumns
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position INTEGER NOT NULL, -- 1 is the top of the list
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_items_user_id_position_idx ON watchlist_items (user_id, position);
+CREATE INDEX IF NOT EXISTS watchlist_items_movie_id_idx ON watchlist_items (movie_id);
+
+-- a movie can be watched more than once so entries get their own id
+CREATE TABLE IF NOT EXISTS watched_movies (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on DATE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS watched_movies_user_id_watched_on_idx ON watched_movies (user_id, watched_on);
+CREATE INDEX IF NOT EXISTS watched_movies_movie_id_idx ON watched_movies (movie_id);
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    owner_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    visibility TEXT NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'unlisted', 'public')),
+    -- kept in plain text so that editors can hand the link out again, it only grants read access
+    share_token TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT collections_share_token_key UNIQUE (share_token)
+);
+
+CREATE INDEX IF NOT EXISTS collections_owner_id_idx ON collections (owner_id);
+CREATE INDEX IF NOT EXISTS collections_title_idx ON collections USING GIN (to_tsvector('simple', title));
+
+-- column names must not clash with those of movies since the two are joined without qualifying movieColumns
+CREATE TABLE IF NOT EXISTS collection_entries (
+    collection_id BIGINT NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id BIGINT NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position INTEGER NOT NULL, -- 1 is the top of the collection
+    note TEXT NOT NULL DEFAULT '',
+    added_by BIGINT REFERENCES users ON DELETE SET NULL,
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_entries_collection_id_position_idx ON collection_entries (collection_id, position);
+CREATE INDEX IF NOT EXISTS collection_entries_movie_id_idx ON collection_entries (movie_id);
+CREATE INDEX IF NOT EXISTS collection_entries_added_by_idx ON collection_entries (added_by);
+
+-- users other than the owner who can edit the collection
+CREATE TABLE IF NOT EXISTS collection_collaborators (
+    collection_id BIGINT NOT NULL REFERENCES collections ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    added_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_collaborators_user_id_idx ON collection_collaborators (user_id);
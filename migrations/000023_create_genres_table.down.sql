@@ -0,0 +1,2 @@
+-- movies keep their canonical slugs, the free text they had before can't be recovered
+DROP TABLE IF EXISTS genres;
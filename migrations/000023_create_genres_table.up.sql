@@ -0,0 +1,110 @@
+-- canonical genres, movies.genres holds their slugs
+-- aliases are stored lower case with single spaces, the way data.normaliseGenre leaves them
+CREATE TABLE IF NOT EXISTS genres (
+    id BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    slug TEXT NOT NULL,
+    name TEXT NOT NULL,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    parent_id BIGINT REFERENCES genres ON DELETE SET NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT genres_slug_key UNIQUE (slug),
+    CONSTRAINT genres_slug_check CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$')
+);
+
+CREATE INDEX IF NOT EXISTS genres_aliases_idx ON genres USING GIN (aliases);
+
+INSERT INTO genres (slug, name, aliases)
+VALUES
+    ('action', 'Action', '{}'),
+    ('adventure', 'Adventure', '{}'),
+    ('animation', 'Animation', '{"animated", "cartoon", "anime"}'),
+    ('biography', 'Biography', '{"biopic", "biographical"}'),
+    ('comedy', 'Comedy', '{"comedies"}'),
+    ('romantic-comedy', 'Romantic Comedy', '{"rom-com", "romcom", "rom com"}'),
+    ('crime', 'Crime', '{}'),
+    ('film-noir', 'Film Noir', '{"noir"}'),
+    ('documentary', 'Documentary', '{"documentaries", "doc"}'),
+    ('drama', 'Drama', '{"dramas"}'),
+    ('family', 'Family', '{}'),
+    ('fantasy', 'Fantasy', '{}'),
+    ('history', 'History', '{"historical"}'),
+    ('horror', 'Horror', '{}'),
+    ('musical', 'Musical', '{"music"}'),
+    ('mystery', 'Mystery', '{}'),
+    ('romance', 'Romance', '{"romantic"}'),
+    ('science-fiction', 'Science Fiction', '{"sci-fi", "scifi", "sci fi", "sf"}'),
+    ('sport', 'Sport', '{"sports"}'),
+    ('thriller', 'Thriller', '{"suspense"}'),
+    ('war', 'War', '{}'),
+    ('western', 'Western', '{"westerns"}')
+ON CONFLICT (slug) DO NOTHING;
+
+UPDATE genres
+SET parent_id = (SELECT id FROM genres AS parent WHERE parent.slug = 'comedy')
+WHERE slug = 'romantic-comedy';
+
+UPDATE genres
+SET parent_id = (SELECT id FROM genres AS parent WHERE parent.slug = 'crime')
+WHERE slug = 'film-noir';
+
+-- genres in use that the taxonomy doesn't know about become genres of their own so that no movie loses one
+-- the slug expression mirrors data.genreSlug
+WITH used AS (
+    SELECT DISTINCT
+        lower(regexp_replace(trim(genre), '\s+', ' ', 'g')) AS normalised,
+        trim(BOTH '-' FROM regexp_replace(lower(genre), '[^a-z0-9]+', '-', 'g')) AS slug
+    FROM movies, unnest(movies.genres) AS genre
+)
+INSERT INTO genres (slug, name)
+SELECT DISTINCT used.slug, initcap(replace(used.slug, '-', ' '))
+FROM used
+WHERE used.slug <> ''
+AND NOT EXISTS (
+    SELECT 1
+    FROM genres AS known
+    WHERE known.slug = used.slug
+    OR used.normalised = ANY(known.aliases)
+)
+ON CONFLICT (slug) DO NOTHING;
+
+-- replaces every genre with its canonical slug, keeping the original order and dropping duplicates
+-- movies whose genres change get a new version and a revision like any other edit
+-- so that ETags handed out for the old genres stop matching
+-- the snapshot mirrors the JSON of data.Movie
+-- genres without a usable slug eg ??? have no row to resolve to and are dropped, the revision's diff records them
+-- movies left with no genres at all would fail validation on every later edit so they are kept as they are
+WITH canonical AS (
+    SELECT movies.id, movies.genres AS old_genres, ARRAY(
+        SELECT known.slug
+        FROM unnest(movies.genres) WITH ORDINALITY AS given (genre, n)
+        INNER JOIN genres AS known
+            ON known.slug = trim(BOTH '-' FROM regexp_replace(lower(given.genre), '[^a-z0-9]+', '-', 'g'))
+            OR lower(regexp_replace(trim(given.genre), '\s+', ' ', 'g')) = ANY(known.aliases)
+        GROUP BY known.slug
+        ORDER BY min(given.n)
+    ) AS new_genres
+    FROM movies
+), changed AS (
+    UPDATE movies
+    SET genres = canonical.new_genres, version = movies.version + 1, updated_at = NOW()
+    FROM canonical
+    WHERE canonical.id = movies.id
+    AND canonical.new_genres IS DISTINCT FROM canonical.old_genres
+    AND cardinality(canonical.new_genres) > 0
+    RETURNING movies.*, canonical.old_genres
+)
+INSERT INTO movie_revisions (movie_id, version, action, snapshot, diff)
+SELECT id, version, 'update',
+    jsonb_strip_nulls(jsonb_build_object(
+        'id', id,
+        'title', title,
+        'year', NULLIF(year, 0),
+        'runtime', NULLIF(runtime, 0) || ' mins',
+        'genres', NULLIF(genres, '{}'),
+        'version', version,
+        'deleted_at', deleted_at,
+        'deleted_by', deleted_by,
+        'external_ids', NULLIF(jsonb_strip_nulls(jsonb_build_object('imdb', imdb_id, 'tmdb', tmdb_id, 'wikidata', wikidata_id)), '{}')
+    )),
+    jsonb_build_object('genres', jsonb_build_object('from', old_genres, 'to', genres))
+FROM changed;